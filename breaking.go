@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sqlc-dev/sqlc/internal/plugin"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Kinds of breaking changes reported by CheckBreaking.
+const (
+	BreakingFieldNumberChanged  = "FIELD_NUMBER_CHANGED"
+	BreakingFieldNumberReused   = "FIELD_NUMBER_REUSED"
+	BreakingFieldTypeChanged    = "FIELD_TYPE_CHANGED"
+	BreakingFieldRemoved        = "FIELD_REMOVED"
+	BreakingMessageRemoved      = "MESSAGE_REMOVED"
+	BreakingEnumRemoved         = "ENUM_REMOVED"
+	BreakingEnumValueRemoved    = "ENUM_VALUE_REMOVED"
+	BreakingEnumValueRenamed    = "ENUM_VALUE_RENAMED"
+	BreakingEnumValueNumChanged = "ENUM_VALUE_NUMBER_CHANGED"
+	BreakingRPCRemoved          = "RPC_REMOVED"
+	BreakingRPCSignatureChanged = "RPC_SIGNATURE_CHANGED"
+	BreakingHTTPRuleChanged     = "HTTP_RULE_CHANGED"
+
+	httpRuleOption = "google.api.http"
+)
+
+// BreakingChange is a single wire or source breaking difference between
+// the protos in out_dir and the ones about to be generated.
+type BreakingChange struct {
+	File    string // Path relative to out_dir
+	Element string // Fully qualified element, ex: foo.v1.Users.name
+	Kind    string // One of the Breaking* constants
+	Old     string
+	New     string
+}
+
+func (b BreakingChange) String() string {
+	return fmt.Sprintf("%s: %s: %s: %q -> %q", b.File, b.Element, b.Kind, b.Old, b.New)
+}
+
+// BreakingChanges is returned as the error of CheckBreaking.
+type BreakingChanges []BreakingChange
+
+func (bc BreakingChanges) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(
+		&sb,
+		"%d breaking change(s) detected, set allow_breaking: true to override:",
+		len(bc),
+	)
+	for _, b := range bc {
+		sb.WriteString("\n  ")
+		sb.WriteString(b.String())
+	}
+
+	return sb.String()
+}
+
+// CheckBreaking diffs every proto in out_dir that files would overwrite,
+// and the protos the lock recorded as generated by the last run, against
+// files.  Both sides go through the same parser so type names and options
+// are compared in the exact form they are printed in.
+func (p Protos) CheckBreaking(files []*plugin.File) error {
+	if p.options.AllowBreaking {
+		return nil
+	}
+
+	// Without a lock, ex: the first run after upgrading, the overwritten
+	// files are still diffed, the lock only adds the removed ones.
+	var candidates []string
+	for _, file := range files {
+		candidates = append(candidates, file.Name)
+	}
+	if p.lock != nil {
+		candidates = append(candidates, p.lock.Files...)
+	}
+	oldPaths, err := previousProtos(p.options.OutDir, candidates)
+	if err != nil {
+		return err
+	}
+
+	var changes BreakingChanges
+	generated := make(map[string]bool)
+	for _, file := range files {
+		generated[file.Name] = true
+		if !oldPaths[file.Name] {
+			continue
+		}
+		oldFile, err := parseProtoFile(filepath.Join(p.options.OutDir, file.Name))
+		if err != nil {
+			return fmt.Errorf("%q: parsing previously generated proto: %w", file.Name, err)
+		}
+		newFile, err := parseProto(filepath.Base(file.Name), bytes.NewReader(file.Contents))
+		if err != nil {
+			return err
+		}
+		changes = append(changes, diffFiles(file.Name, oldFile, newFile)...)
+	}
+
+	// Files that are no longer generated take their RPCs with them.
+	for oldPath := range oldPaths {
+		if generated[oldPath] {
+			continue
+		}
+		oldFile, err := parseProtoFile(filepath.Join(p.options.OutDir, oldPath))
+		if err != nil {
+			return fmt.Errorf("%q: parsing previously generated proto: %w", oldPath, err)
+		}
+		changes = append(changes, diffFiles(oldPath, oldFile, &descriptorpb.FileDescriptorProto{})...)
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].File != changes[j].File {
+			return changes[i].File < changes[j].File
+		}
+		return changes[i].Element < changes[j].Element
+	})
+
+	return changes
+}
+
+// previousProtos returns the paths that exist in dir.  Hand written or
+// vendored protos in out_dir are never diffed unless a generated file
+// would overwrite them.
+func previousProtos(dir string, candidates []string) (map[string]bool, error) {
+	paths := make(map[string]bool)
+	for _, path := range candidates {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		paths[path] = true
+	}
+
+	return paths, nil
+}
+
+func diffFiles(
+	path string,
+	oldFile *descriptorpb.FileDescriptorProto,
+	newFile *descriptorpb.FileDescriptorProto,
+) []BreakingChange {
+	var changes []BreakingChange
+	pkg := oldFile.GetPackage()
+
+	newMessages := make(map[string]*descriptorpb.DescriptorProto)
+	for _, m := range newFile.GetMessageType() {
+		newMessages[m.GetName()] = m
+	}
+	for _, om := range oldFile.GetMessageType() {
+		changes = append(changes, diffMessages(path, pkg, om, newMessages[om.GetName()])...)
+	}
+
+	newEnums := make(map[string]*descriptorpb.EnumDescriptorProto)
+	for _, e := range newFile.GetEnumType() {
+		newEnums[e.GetName()] = e
+	}
+	for _, oe := range oldFile.GetEnumType() {
+		changes = append(changes, diffEnums(path, pkg, oe, newEnums[oe.GetName()])...)
+	}
+
+	newServices := make(map[string]*descriptorpb.ServiceDescriptorProto)
+	for _, s := range newFile.GetService() {
+		newServices[s.GetName()] = s
+	}
+	for _, osvc := range oldFile.GetService() {
+		changes = append(changes, diffServices(path, pkg, osvc, newServices[osvc.GetName()])...)
+	}
+
+	return changes
+}
+
+func diffMessages(
+	path string,
+	scope string,
+	om *descriptorpb.DescriptorProto,
+	nm *descriptorpb.DescriptorProto,
+) []BreakingChange {
+	var changes []BreakingChange
+	mName := fmt.Sprintf("%s.%s", scope, om.GetName())
+	if nm == nil {
+		return []BreakingChange{{
+			File:    path,
+			Element: mName,
+			Kind:    BreakingMessageRemoved,
+			Old:     om.GetName(),
+		}}
+	}
+
+	byName := make(map[string]*descriptorpb.FieldDescriptorProto)
+	byNumber := make(map[int32]*descriptorpb.FieldDescriptorProto)
+	for _, f := range nm.GetField() {
+		byName[f.GetName()] = f
+		byNumber[f.GetNumber()] = f
+	}
+
+	for _, of := range om.GetField() {
+		fName := fmt.Sprintf("%s.%s", mName, of.GetName())
+		if nf, ok := byName[of.GetName()]; ok {
+			if nf.GetNumber() != of.GetNumber() {
+				changes = append(changes, BreakingChange{
+					File:    path,
+					Element: fName,
+					Kind:    BreakingFieldNumberChanged,
+					Old:     fmt.Sprint(of.GetNumber()),
+					New:     fmt.Sprint(nf.GetNumber()),
+				})
+			}
+			if ot, nt := fieldTypeString(of), fieldTypeString(nf); ot != nt {
+				changes = append(changes, BreakingChange{
+					File:    path,
+					Element: fName,
+					Kind:    BreakingFieldTypeChanged,
+					Old:     ot,
+					New:     nt,
+				})
+			}
+			continue
+		}
+		if nf, ok := byNumber[of.GetNumber()]; ok {
+			changes = append(changes, BreakingChange{
+				File:    path,
+				Element: fName,
+				Kind:    BreakingFieldNumberReused,
+				Old:     fmt.Sprintf("%s = %d", of.GetName(), of.GetNumber()),
+				New:     fmt.Sprintf("%s = %d", nf.GetName(), nf.GetNumber()),
+			})
+			continue
+		}
+		changes = append(changes, BreakingChange{
+			File:    path,
+			Element: fName,
+			Kind:    BreakingFieldRemoved,
+			Old:     fmt.Sprintf("%s = %d", of.GetName(), of.GetNumber()),
+		})
+	}
+
+	newNested := make(map[string]*descriptorpb.DescriptorProto)
+	for _, n := range nm.GetNestedType() {
+		newNested[n.GetName()] = n
+	}
+	for _, on := range om.GetNestedType() {
+		changes = append(changes, diffMessages(path, mName, on, newNested[on.GetName()])...)
+	}
+
+	newEnums := make(map[string]*descriptorpb.EnumDescriptorProto)
+	for _, e := range nm.GetEnumType() {
+		newEnums[e.GetName()] = e
+	}
+	for _, oe := range om.GetEnumType() {
+		changes = append(changes, diffEnums(path, mName, oe, newEnums[oe.GetName()])...)
+	}
+
+	return changes
+}
+
+// fieldTypeString renders a field's label and type the way it was printed.
+func fieldTypeString(f *descriptorpb.FieldDescriptorProto) string {
+	t := f.GetTypeName()
+	if t == "" {
+		t = strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+	}
+	if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		return "repeated " + t
+	}
+	if f.GetProto3Optional() {
+		return "optional " + t
+	}
+
+	return t
+}
+
+func diffEnums(
+	path string,
+	scope string,
+	oe *descriptorpb.EnumDescriptorProto,
+	ne *descriptorpb.EnumDescriptorProto,
+) []BreakingChange {
+	var changes []BreakingChange
+	eName := fmt.Sprintf("%s.%s", scope, oe.GetName())
+	if ne == nil {
+		return []BreakingChange{{
+			File:    path,
+			Element: eName,
+			Kind:    BreakingEnumRemoved,
+			Old:     oe.GetName(),
+		}}
+	}
+
+	byName := make(map[string]int32)
+	byNumber := make(map[int32]string)
+	for _, v := range ne.GetValue() {
+		byName[v.GetName()] = v.GetNumber()
+		if _, ok := byNumber[v.GetNumber()]; !ok {
+			byNumber[v.GetNumber()] = v.GetName()
+		}
+	}
+
+	for _, ov := range oe.GetValue() {
+		vName := fmt.Sprintf("%s.%s", eName, ov.GetName())
+		if num, ok := byName[ov.GetName()]; ok {
+			if num != ov.GetNumber() {
+				changes = append(changes, BreakingChange{
+					File:    path,
+					Element: vName,
+					Kind:    BreakingEnumValueNumChanged,
+					Old:     fmt.Sprint(ov.GetNumber()),
+					New:     fmt.Sprint(num),
+				})
+			}
+			continue
+		}
+		if n, ok := byNumber[ov.GetNumber()]; ok {
+			changes = append(changes, BreakingChange{
+				File:    path,
+				Element: vName,
+				Kind:    BreakingEnumValueRenamed,
+				Old:     ov.GetName(),
+				New:     n,
+			})
+			continue
+		}
+		changes = append(changes, BreakingChange{
+			File:    path,
+			Element: vName,
+			Kind:    BreakingEnumValueRemoved,
+			Old:     fmt.Sprintf("%s = %d", ov.GetName(), ov.GetNumber()),
+		})
+	}
+
+	return changes
+}
+
+func diffServices(
+	path string,
+	pkg string,
+	osvc *descriptorpb.ServiceDescriptorProto,
+	nsvc *descriptorpb.ServiceDescriptorProto,
+) []BreakingChange {
+	var changes []BreakingChange
+	sName := fmt.Sprintf("%s.%s", pkg, osvc.GetName())
+
+	newMethods := make(map[string]*descriptorpb.MethodDescriptorProto)
+	for _, m := range nsvc.GetMethod() {
+		newMethods[m.GetName()] = m
+	}
+
+	for _, om := range osvc.GetMethod() {
+		mName := fmt.Sprintf("%s.%s", sName, om.GetName())
+		nm, ok := newMethods[om.GetName()]
+		if !ok {
+			changes = append(changes, BreakingChange{
+				File:    path,
+				Element: mName,
+				Kind:    BreakingRPCRemoved,
+				Old:     methodSignature(om),
+			})
+			continue
+		}
+		if o, n := methodSignature(om), methodSignature(nm); o != n {
+			changes = append(changes, BreakingChange{
+				File:    path,
+				Element: mName,
+				Kind:    BreakingRPCSignatureChanged,
+				Old:     o,
+				New:     n,
+			})
+		}
+		if o, n := httpRule(om), httpRule(nm); o != n {
+			changes = append(changes, BreakingChange{
+				File:    path,
+				Element: mName,
+				Kind:    BreakingHTTPRuleChanged,
+				Old:     o,
+				New:     n,
+			})
+		}
+	}
+
+	return changes
+}
+
+func methodSignature(m *descriptorpb.MethodDescriptorProto) string {
+	in, out := m.GetInputType(), m.GetOutputType()
+	if m.GetClientStreaming() {
+		in = "stream " + in
+	}
+	if m.GetServerStreaming() {
+		out = "stream " + out
+	}
+
+	return fmt.Sprintf("(%s) returns (%s)", in, out)
+}
+
+// httpRule returns the uninterpreted google.api.http option of a method
+// with whitespace normalized.
+func httpRule(m *descriptorpb.MethodDescriptorProto) string {
+	for _, uo := range m.GetOptions().GetUninterpretedOption() {
+		var parts []string
+		for _, n := range uo.GetName() {
+			parts = append(parts, n.GetNamePart())
+		}
+		if strings.Join(parts, ".") != httpRuleOption {
+			continue
+		}
+		return strings.Join(strings.Fields(uo.GetAggregateValue()), " ")
+	}
+
+	return ""
+}
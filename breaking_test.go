@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sqlc-dev/sqlc/internal/plugin"
+)
+
+const breakingBase = `syntax = "proto3";
+package foo.v1;
+
+message Users {
+  bytes uuid = 1;
+  string name = 2;
+  message Nested {
+    string a = 1;
+  }
+}
+
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_ACTIVE = 1;
+}
+
+service Iam {
+  rpc GetUsers ( Users ) returns ( Users ) {
+    option (google.api.http) = { get: "/v1/users/{uuid}" };
+  }
+}
+`
+
+func TestDiffFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// old and new replace a line of breakingBase, an empty new removes it.
+		old, new string
+		want     []string // Element Kind
+	}{
+		{
+			name: "unchanged",
+		},
+		{
+			name: "field removed",
+			old:  "  string name = 2;\n",
+			want: []string{"foo.v1.Users.name FIELD_REMOVED"},
+		},
+		{
+			name: "field number reused",
+			old:  "  string name = 2;\n",
+			new:  "  string email = 2;\n",
+			want: []string{"foo.v1.Users.name FIELD_NUMBER_REUSED"},
+		},
+		{
+			name: "field number changed",
+			old:  "  string name = 2;\n",
+			new:  "  string name = 3;\n",
+			want: []string{"foo.v1.Users.name FIELD_NUMBER_CHANGED"},
+		},
+		{
+			name: "field type changed",
+			old:  "  bytes uuid = 1;\n",
+			new:  "  string uuid = 1;\n",
+			want: []string{"foo.v1.Users.uuid FIELD_TYPE_CHANGED"},
+		},
+		{
+			name: "field added",
+			old:  "  string name = 2;\n",
+			new:  "  string name = 2;\n  string email = 3;\n",
+		},
+		{
+			name: "nested message removed",
+			old:  "  message Nested {\n    string a = 1;\n  }\n",
+			want: []string{"foo.v1.Users.Nested MESSAGE_REMOVED"},
+		},
+		{
+			name: "enum value removed",
+			old:  "  STATUS_ACTIVE = 1;\n",
+			want: []string{"foo.v1.Status.STATUS_ACTIVE ENUM_VALUE_REMOVED"},
+		},
+		{
+			name: "enum value renamed",
+			old:  "  STATUS_ACTIVE = 1;\n",
+			new:  "  STATUS_ENABLED = 1;\n",
+			want: []string{"foo.v1.Status.STATUS_ACTIVE ENUM_VALUE_RENAMED"},
+		},
+		{
+			name: "enum removed",
+			old:  "enum Status {\n  STATUS_UNSPECIFIED = 0;\n  STATUS_ACTIVE = 1;\n}\n",
+			want: []string{"foo.v1.Status ENUM_REMOVED"},
+		},
+		{
+			name: "rpc removed",
+			old:  "  rpc GetUsers ( Users ) returns ( Users ) {\n    option (google.api.http) = { get: \"/v1/users/{uuid}\" };\n  }\n",
+			want: []string{"foo.v1.Iam.GetUsers RPC_REMOVED"},
+		},
+		{
+			name: "http rule changed",
+			old:  "/v1/users/{uuid}",
+			new:  "/v1/people/{uuid}",
+			want: []string{"foo.v1.Iam.GetUsers HTTP_RULE_CHANGED"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newSrc := breakingBase
+			if tt.old != "" {
+				if !strings.Contains(newSrc, tt.old) {
+					t.Fatalf("%q not in the base proto", tt.old)
+				}
+				newSrc = strings.Replace(newSrc, tt.old, tt.new, 1)
+			}
+			oldFile, err := parseProto("message.proto", strings.NewReader(breakingBase))
+			if err != nil {
+				t.Fatal(err)
+			}
+			newFile, err := parseProto("message.proto", strings.NewReader(newSrc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, c := range diffFiles("message.proto", oldFile, newFile) {
+				got = append(got, c.Element+" "+c.Kind)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Files about to be overwritten and files the lock recorded are diffed,
+// anything else in out_dir is left alone even when it can't be parsed.
+func TestCheckBreakingRecordedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for path, src := range map[string]string{
+		"foo/v1/message.proto":     breakingBase,
+		"foo/v1/service.proto":     breakingBase,
+		"vendor/handwritten.proto": "syntax = \"proto3\";\nthis does not parse\n",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []*plugin.File{{
+		Name:     "foo/v1/message.proto",
+		Contents: []byte(strings.Replace(breakingBase, "  string name = 2;\n", "", 1)),
+	}}
+
+	tests := []struct {
+		name     string
+		noLock   bool
+		recorded []string
+		want     []string // Element Kind
+	}{
+		{
+			name:   "missing lock with existing generated files",
+			noLock: true,
+			want:   []string{"foo.v1.Users.name FIELD_REMOVED"},
+		},
+		{
+			name: "nothing recorded",
+			want: []string{"foo.v1.Users.name FIELD_REMOVED"},
+		},
+		{
+			name:     "recorded file changed",
+			recorded: []string{"foo/v1/message.proto"},
+			want:     []string{"foo.v1.Users.name FIELD_REMOVED"},
+		},
+		{
+			name:     "recorded file no longer generated",
+			recorded: []string{"foo/v1/service.proto"},
+			want: []string{
+				"foo.v1.Users.name FIELD_REMOVED",
+				"foo.v1.Iam.GetUsers RPC_REMOVED",
+				"foo.v1.Status ENUM_REMOVED",
+				"foo.v1.Users MESSAGE_REMOVED",
+			},
+		},
+		{
+			name:     "recorded file deleted by hand",
+			recorded: []string{"foo/v1/missing.proto"},
+			want:     []string{"foo.v1.Users.name FIELD_REMOVED"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Protos{options: &options{OutDir: dir}}
+			if !tt.noLock {
+				p.lock = newLock()
+				p.lock.Files = tt.recorded
+			}
+
+			err := p.CheckBreaking(files)
+			var changes BreakingChanges
+			if err != nil && !errors.As(err, &changes) {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range changes {
+				got = append(got, c.Element+" "+c.Kind)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
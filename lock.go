@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jhump/protoreflect/v2/protobuilder"
+	"github.com/sqlc-dev/sqlc/internal/plugin"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// First and last field number reserved by the protobuf implementation.
+const (
+	reservedFieldStart = 19000
+	reservedFieldEnd   = 19999
+)
+
+// Lock records every field and enum value number handed out by a previous
+// run.  Entries are never removed, so a dropped column's number is never
+// given to a new one, and the lock doubles as the history used to emit
+// reserved numbers and names.
+//
+// map[ $package.$message ][ $field ] = $number
+type Lock struct {
+	Messages map[string]map[string]int32 `json:"messages"`
+	Enums    map[string]map[string]int32 `json:"enums"`
+	// Files generated by the last run, relative to out_dir.  CheckBreaking
+	// diffs them to find the ones no longer generated.
+	Files []string `json:"files,omitempty"`
+}
+
+func newLock() *Lock {
+	return &Lock{
+		Messages: make(map[string]map[string]int32),
+		Enums:    make(map[string]map[string]int32),
+	}
+}
+
+// readLock returns an empty Lock if the file does not exist yet.
+func readLock(path string) (*Lock, error) {
+	lock := newLock()
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, lock); err != nil {
+		return nil, fmt.Errorf("%q: invalid lock file: %w", path, err)
+	}
+	if lock.Messages == nil {
+		lock.Messages = make(map[string]map[string]int32)
+	}
+	if lock.Enums == nil {
+		lock.Enums = make(map[string]map[string]int32)
+	}
+
+	return lock, nil
+}
+
+func (l *Lock) marshal() ([]byte, error) {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+// write only touches the file when its contents changed.
+func (l *Lock) write(path string) error {
+	b, err := l.marshal()
+	if err != nil {
+		return err
+	}
+
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, b) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0o644)
+}
+
+// usedNumbers inverts a locked message or enum and fails on duplicates,
+// which can only come from hand editing the lock file.
+func usedNumbers(name string, locked map[string]int32) (map[int32]string, error) {
+	used := make(map[int32]string)
+	for n, num := range locked {
+		if other, ok := used[num]; ok {
+			return nil, fmt.Errorf(
+				"%q: lock file assigns number %d to both %q and %q",
+				name, num, other, n,
+			)
+		}
+		used[num] = n
+	}
+
+	return used, nil
+}
+
+// dropped returns the sorted names and numbers that are locked but no
+// longer present.
+func dropped(locked map[string]int32, present map[string]bool) ([]string, []int32) {
+	var names []string
+	var nums []int32
+	for n, num := range locked {
+		if present[n] {
+			continue
+		}
+		names = append(names, n)
+		nums = append(nums, num)
+	}
+	sort.Strings(names)
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	return names, nums
+}
+
+// toRanges collapses sorted numbers into inclusive [start, end] ranges.
+func toRanges(nums []int32) [][2]int32 {
+	var ranges [][2]int32
+	for _, num := range nums {
+		if l := len(ranges); l > 0 && ranges[l-1][1]+1 == num {
+			ranges[l-1][1] = num
+			continue
+		}
+		ranges = append(ranges, [2]int32{num, num})
+	}
+
+	return ranges
+}
+
+// messageFields returns every field of a message, including oneof choices.
+func messageFields(mb *protobuilder.MessageBuilder) []*protobuilder.FieldBuilder {
+	var fields []*protobuilder.FieldBuilder
+	for _, child := range mb.Children() {
+		switch c := child.(type) {
+		case *protobuilder.FieldBuilder:
+			if !c.IsExtension() {
+				fields = append(fields, c)
+			}
+		case *protobuilder.OneofBuilder:
+			for _, choice := range c.Children() {
+				fields = append(fields, choice.(*protobuilder.FieldBuilder))
+			}
+		}
+	}
+
+	return fields
+}
+
+func (l *Lock) lockMessage(mb *protobuilder.MessageBuilder) error {
+	mName := string(protobuilder.FullName(mb))
+	locked := l.Messages[mName]
+	if locked == nil {
+		locked = make(map[string]int32)
+		l.Messages[mName] = locked
+	}
+	used, err := usedNumbers(mName, locked)
+	if err != nil {
+		return err
+	}
+
+	var unlocked []*protobuilder.FieldBuilder
+	present := make(map[string]bool)
+	for _, fb := range messageFields(mb) {
+		fName := string(fb.Name())
+		present[fName] = true
+		num, ok := locked[fName]
+		if !ok {
+			if fb.Number() == 0 {
+				unlocked = append(unlocked, fb)
+				continue
+			}
+			// Explicitly numbered, only make sure nothing else owns it.
+			if other, ok := used[int32(fb.Number())]; ok {
+				return fmt.Errorf(
+					"%s.%s: field number %d is locked to %q",
+					mName, fName, fb.Number(), other,
+				)
+			}
+			locked[fName] = int32(fb.Number())
+			used[int32(fb.Number())] = fName
+			continue
+		}
+		if fb.Number() != 0 && int32(fb.Number()) != num {
+			return fmt.Errorf(
+				"%s.%s: field number would change from %d to %d",
+				mName, fName, num, fb.Number(),
+			)
+		}
+		if err := fb.TrySetNumber(protoreflect.FieldNumber(num)); err != nil {
+			return err
+		}
+	}
+
+	// Reserve everything the message used to contain.
+	names, nums := dropped(locked, present)
+	for _, n := range names {
+		mb.AddReservedName(protoreflect.Name(n))
+	}
+	for _, r := range toRanges(nums) {
+		// Message ranges exclude the end.
+		mb.AddReservedRange(protoreflect.FieldNumber(r[0]), protoreflect.FieldNumber(r[1]+1))
+	}
+
+	next := int32(1)
+	for _, fb := range unlocked {
+		for {
+			_, ok := used[next]
+			if !ok && (next < reservedFieldStart || next > reservedFieldEnd) {
+				break
+			}
+			next++
+		}
+		if err := fb.TrySetNumber(protoreflect.FieldNumber(next)); err != nil {
+			return err
+		}
+		locked[string(fb.Name())] = next
+		used[next] = string(fb.Name())
+	}
+
+	for _, child := range mb.Children() {
+		switch c := child.(type) {
+		case *protobuilder.MessageBuilder:
+			if err := l.lockMessage(c); err != nil {
+				return err
+			}
+		case *protobuilder.EnumBuilder:
+			if err := l.lockEnum(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *Lock) lockEnum(eb *protobuilder.EnumBuilder) error {
+	eName := string(protobuilder.FullName(eb))
+	locked := l.Enums[eName]
+	if locked == nil {
+		locked = make(map[string]int32)
+		l.Enums[eName] = locked
+	}
+	used, err := usedNumbers(eName, locked)
+	if err != nil {
+		return err
+	}
+
+	var unlocked []*protobuilder.EnumValueBuilder
+	present := make(map[string]bool)
+	for _, child := range eb.Children() {
+		evb := child.(*protobuilder.EnumValueBuilder)
+		vName := string(evb.Name())
+		present[vName] = true
+		num, ok := locked[vName]
+		if !ok {
+			if !evb.HasNumber() {
+				unlocked = append(unlocked, evb)
+				continue
+			}
+			if other, ok := used[int32(evb.Number())]; ok {
+				return fmt.Errorf(
+					"%s.%s: enum number %d is locked to %q",
+					eName, vName, evb.Number(), other,
+				)
+			}
+			locked[vName] = int32(evb.Number())
+			used[int32(evb.Number())] = vName
+			continue
+		}
+		if evb.HasNumber() && int32(evb.Number()) != num {
+			return fmt.Errorf(
+				"%s.%s: enum number would change from %d to %d",
+				eName, vName, num, evb.Number(),
+			)
+		}
+		evb.SetNumber(protoreflect.EnumNumber(num))
+	}
+
+	// Reserve every value the enum used to contain.
+	names, nums := dropped(locked, present)
+	for _, n := range names {
+		eb.AddReservedName(protoreflect.Name(n))
+	}
+	for _, r := range toRanges(nums) {
+		eb.AddReservedRange(protoreflect.EnumNumber(r[0]), protoreflect.EnumNumber(r[1]))
+	}
+
+	// Enums start at 0 so a new enum keeps *_UNSPECIFIED = 0.
+	next := int32(0)
+	for _, evb := range unlocked {
+		for {
+			if _, ok := used[next]; !ok {
+				break
+			}
+			next++
+		}
+		evb.SetNumber(protoreflect.EnumNumber(next))
+		locked[string(evb.Name())] = next
+		used[next] = string(evb.Name())
+	}
+
+	return nil
+}
+
+// recordFiles replaces the files generated by the last run.
+func (l *Lock) recordFiles(files []*plugin.File) {
+	l.Files = make([]string, 0, len(files))
+	for _, file := range files {
+		l.Files = append(l.Files, file.Name)
+	}
+	sort.Strings(l.Files)
+}
+
+// ApplyLock numbers every message field and enum value from the lock file,
+// handing new ones the lowest number never used before.
+func (p *Protos) ApplyLock() error {
+	lock, err := readLock(p.options.LockFile)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range p.GetFiles() {
+		for _, child := range file.Children() {
+			switch c := child.(type) {
+			case *protobuilder.MessageBuilder:
+				if err := lock.lockMessage(c); err != nil {
+					return err
+				}
+			case *protobuilder.EnumBuilder:
+				if err := lock.lockEnum(c); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	p.lock = lock
+
+	return nil
+}
+
+// WriteLock persists the lock under write_to_disk, it must only run once
+// generation succeeded.
+func (p Protos) WriteLock() error {
+	if p.lock == nil {
+		return nil
+	}
+
+	return p.lock.write(p.options.LockFile)
+}
+
+// LockFile returns the lock for sqlc to write next to the protos, so sqlc
+// diff and dry runs never touch the committed lock.  sqlc resolves the name
+// against the codegen out, which parseOptions made out_dir, so it lands on
+// lock_file, the same file ApplyLock reads.
+func (p Protos) LockFile() (*plugin.File, error) {
+	if p.lock == nil {
+		return nil, nil
+	}
+	b, err := p.lock.marshal()
+	if err != nil {
+		return nil, err
+	}
+	outDir, err := filepath.Abs(p.options.OutDir)
+	if err != nil {
+		return nil, err
+	}
+	lockFile, err := filepath.Abs(p.options.LockFile)
+	if err != nil {
+		return nil, err
+	}
+	name, err := filepath.Rel(outDir, lockFile)
+	if err != nil {
+		return nil, fmt.Errorf("%q: lock_file must be relative to out_dir: %w", p.options.LockFile, err)
+	}
+
+	return &plugin.File{Name: filepath.ToSlash(name), Contents: b}, nil
+}
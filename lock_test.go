@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/v2/protobuilder"
+	"github.com/jhump/protoreflect/v2/protoprint"
+	"github.com/sqlc-dev/sqlc/internal/plugin"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestToRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		nums []int32
+		want [][2]int32
+	}{
+		{name: "empty", nums: nil, want: nil},
+		{name: "single", nums: []int32{3}, want: [][2]int32{{3, 3}}},
+		{name: "contiguous", nums: []int32{3, 4, 5}, want: [][2]int32{{3, 5}}},
+		{
+			name: "gaps",
+			nums: []int32{1, 3, 4, 7},
+			want: [][2]int32{{1, 1}, {3, 4}, {7, 7}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toRanges(tt.nums); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toRanges(%v) = %v, want %v", tt.nums, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropped(t *testing.T) {
+	tests := []struct {
+		name      string
+		locked    map[string]int32
+		present   map[string]bool
+		wantNames []string
+		wantNums  []int32
+	}{
+		{
+			name:    "nothing dropped",
+			locked:  map[string]int32{"a": 1, "b": 2},
+			present: map[string]bool{"a": true, "b": true},
+		},
+		{
+			name:      "sorted by name and number",
+			locked:    map[string]int32{"c": 2, "a": 5, "b": 3},
+			present:   map[string]bool{"b": true},
+			wantNames: []string{"a", "c"},
+			wantNums:  []int32{2, 5},
+		},
+		{
+			name:      "everything dropped",
+			locked:    map[string]int32{"a": 1},
+			present:   map[string]bool{},
+			wantNames: []string{"a"},
+			wantNums:  []int32{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, nums := dropped(tt.locked, tt.present)
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("names = %v, want %v", names, tt.wantNames)
+			}
+			if !reflect.DeepEqual(nums, tt.wantNums) {
+				t.Errorf("nums = %v, want %v", nums, tt.wantNums)
+			}
+		})
+	}
+}
+
+// lockedRange locks the names f<n> to every number from start to end.
+func lockedRange(start, end int32) map[string]int32 {
+	locked := make(map[string]int32)
+	for n := start; n <= end; n++ {
+		locked[fmt.Sprintf("f%d", n)] = n
+	}
+	return locked
+}
+
+func TestLockMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		locked        map[string]int32
+		fields        []string
+		want          map[string]int32
+		wantReserved  int // number of reserved names
+		wantRanges    []protobuilder.FieldRange
+		wantErr       bool
+		explicitField int32 // numbers the last field explicitly
+	}{
+		{
+			name:   "new message",
+			fields: []string{"a", "b"},
+			want:   map[string]int32{"a": 1, "b": 2},
+		},
+		{
+			name:   "existing numbers are kept",
+			locked: map[string]int32{"a": 2, "b": 1},
+			fields: []string{"a", "b"},
+			want:   map[string]int32{"a": 2, "b": 1},
+		},
+		{
+			name:   "new field gets the next number",
+			locked: map[string]int32{"a": 1, "b": 2},
+			fields: []string{"c", "a", "b"},
+			want:   map[string]int32{"a": 1, "b": 2, "c": 3},
+		},
+		{
+			name:         "dropped fields are reserved and never reused",
+			locked:       map[string]int32{"a": 1, "b": 2, "c": 3, "d": 4, "e": 6},
+			fields:       []string{"a", "e", "f"},
+			want:         map[string]int32{"a": 1, "e": 6, "f": 5},
+			wantReserved: 3,
+			wantRanges:   []protobuilder.FieldRange{{2, 5}},
+		},
+		{
+			name:         "skips the implementation reserved numbers",
+			locked:       lockedRange(1, reservedFieldStart-1),
+			fields:       []string{"x"},
+			want:         map[string]int32{"x": reservedFieldEnd + 1},
+			wantReserved: reservedFieldStart - 1,
+			wantRanges:   []protobuilder.FieldRange{{1, reservedFieldStart}},
+		},
+		{
+			name:          "explicit number owned by another field",
+			locked:        map[string]int32{"a": 1},
+			fields:        []string{"b"},
+			explicitField: 1,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := protobuilder.NewMessage("Users")
+			for i, f := range tt.fields {
+				fb := protobuilder.NewField(protoreflect.Name(f), protobuilder.FieldTypeString())
+				if i == len(tt.fields)-1 && tt.explicitField != 0 {
+					fb.SetNumber(protoreflect.FieldNumber(tt.explicitField))
+				}
+				mb.AddField(fb)
+			}
+			protobuilder.NewFile("test.proto").SetPackageName("test.v1").AddMessage(mb)
+
+			lock := newLock()
+			if tt.locked != nil {
+				lock.Messages["test.v1.Users"] = tt.locked
+			}
+			err := lock.lockMessage(mb)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]int32)
+			for _, fb := range messageFields(mb) {
+				got[string(fb.Name())] = int32(fb.Number())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("numbers = %v, want %v", got, tt.want)
+			}
+			if len(mb.ReservedNames) != tt.wantReserved {
+				t.Errorf("reserved %d names, want %d", len(mb.ReservedNames), tt.wantReserved)
+			}
+			if !reflect.DeepEqual(mb.ReservedRanges, tt.wantRanges) {
+				t.Errorf("reserved ranges = %v, want %v", mb.ReservedRanges, tt.wantRanges)
+			}
+			for f, num := range tt.want {
+				if lock.Messages["test.v1.Users"][f] != num {
+					t.Errorf("lock %s = %d, want %d", f, lock.Messages["test.v1.Users"][f], num)
+				}
+			}
+		})
+	}
+}
+
+func TestLockEnum(t *testing.T) {
+	tests := []struct {
+		name         string
+		locked       map[string]int32
+		values       []string
+		want         map[string]int32
+		wantReserved []protoreflect.Name
+		wantRanges   []protobuilder.EnumRange
+	}{
+		{
+			name:   "new enum starts at 0",
+			values: []string{"STATUS_UNSPECIFIED", "STATUS_ACTIVE"},
+			want:   map[string]int32{"STATUS_UNSPECIFIED": 0, "STATUS_ACTIVE": 1},
+		},
+		{
+			name:   "existing numbers are kept",
+			locked: map[string]int32{"STATUS_UNSPECIFIED": 0, "STATUS_ACTIVE": 2},
+			values: []string{"STATUS_UNSPECIFIED", "STATUS_ACTIVE", "STATUS_BANNED"},
+			want:   map[string]int32{"STATUS_UNSPECIFIED": 0, "STATUS_ACTIVE": 2, "STATUS_BANNED": 1},
+		},
+		{
+			name:         "dropped values are reserved and never reused",
+			locked:       map[string]int32{"STATUS_UNSPECIFIED": 0, "STATUS_ACTIVE": 1, "STATUS_INACTIVE": 2},
+			values:       []string{"STATUS_UNSPECIFIED", "STATUS_BANNED"},
+			want:         map[string]int32{"STATUS_UNSPECIFIED": 0, "STATUS_BANNED": 3},
+			wantReserved: []protoreflect.Name{"STATUS_ACTIVE", "STATUS_INACTIVE"},
+			wantRanges:   []protobuilder.EnumRange{{1, 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eb := protobuilder.NewEnum("Status")
+			for _, v := range tt.values {
+				eb.AddValue(protobuilder.NewEnumValue(protoreflect.Name(v)))
+			}
+			protobuilder.NewFile("test.proto").SetPackageName("test.v1").AddEnum(eb)
+
+			lock := newLock()
+			if tt.locked != nil {
+				lock.Enums["test.v1.Status"] = tt.locked
+			}
+			if err := lock.lockEnum(eb); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]int32)
+			for _, child := range eb.Children() {
+				evb := child.(*protobuilder.EnumValueBuilder)
+				got[string(evb.Name())] = int32(evb.Number())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("numbers = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(eb.ReservedNames, tt.wantReserved) {
+				t.Errorf("reserved names = %v, want %v", eb.ReservedNames, tt.wantReserved)
+			}
+			if !reflect.DeepEqual(eb.ReservedRanges, tt.wantRanges) {
+				t.Errorf("reserved ranges = %v, want %v", eb.ReservedRanges, tt.wantRanges)
+			}
+		})
+	}
+}
+
+// Message reserved ranges exclude the end and enum ranges include it, both
+// must print as the same inclusive range.
+func TestReservedRangesPrinted(t *testing.T) {
+	mb := protobuilder.NewMessage("Users")
+	for _, f := range []string{"a", "b"} {
+		mb.AddField(protobuilder.NewField(protoreflect.Name(f), protobuilder.FieldTypeString()))
+	}
+	eb := protobuilder.NewEnum("Status")
+	for _, v := range []string{"STATUS_UNSPECIFIED", "STATUS_A", "STATUS_B"} {
+		eb.AddValue(protobuilder.NewEnumValue(protoreflect.Name(v)))
+	}
+	fb := protobuilder.NewFile("test.proto").SetPackageName("test.v1").SetSyntax(protoreflect.Proto3)
+	fb.AddMessage(mb).AddEnum(eb)
+
+	lock := newLock()
+	lock.Messages["test.v1.Users"] = map[string]int32{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}
+	lock.Enums["test.v1.Status"] = map[string]int32{
+		"STATUS_UNSPECIFIED": 0, "STATUS_A": 1, "STATUS_B": 2,
+		"STATUS_C": 3, "STATUS_D": 4, "STATUS_E": 5,
+	}
+	if err := lock.lockMessage(mb); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.lockEnum(eb); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := fb.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := (&protoprint.Printer{}).PrintProtoFile(fd, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []string{"message Users {", "enum Status {"} {
+		start := strings.Index(out.String(), block)
+		if start == -1 {
+			t.Fatalf("%q not printed:\n%s", block, out.String())
+		}
+		body := out.String()[start:]
+		body = body[:strings.Index(body, "\n}")]
+		if !strings.Contains(body, "reserved 3 to 5;") {
+			t.Errorf("%q does not reserve 3 to 5:\n%s", block, body)
+		}
+	}
+}
+
+// sqlc writes the returned lock relative to the codegen out, which must be
+// the lock_file the next run reads.
+func TestLockFileName(t *testing.T) {
+	abs, err := filepath.Abs("locks/proto.lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		opts     map[string]any
+		out      string
+		wantName string
+	}{
+		{
+			name:     "default next to out",
+			out:      "proto/v1",
+			wantName: "../sqlc-gen-proto.lock",
+		},
+		{
+			name:     "out_dir matching out",
+			opts:     map[string]any{"out_dir": "./proto/v1/"},
+			out:      "proto/v1",
+			wantName: "../sqlc-gen-proto.lock",
+		},
+		{
+			name:     "relative lock_file",
+			opts:     map[string]any{"lock_file": "proto.lock"},
+			out:      "proto/v1",
+			wantName: "../../proto.lock",
+		},
+		{
+			name:     "absolute lock_file",
+			opts:     map[string]any{"lock_file": abs},
+			out:      "proto/v1",
+			wantName: "../../locks/proto.lock",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts, err := parseOptions(&plugin.GenerateRequest{
+				PluginOptions: b,
+				Settings:      &plugin.Settings{Codegen: &plugin.Codegen{Out: tt.out}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := Protos{options: opts, lock: newLock()}
+			file, err := p.LockFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.Name != tt.wantName {
+				t.Errorf("name = %q, want %q", file.Name, tt.wantName)
+			}
+			written, err := filepath.Abs(filepath.Join(tt.out, file.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err := filepath.Abs(opts.LockFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written != read {
+				t.Errorf("sqlc writes %q, lock is read from %q", written, read)
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -20,7 +22,9 @@ import (
 	"github.com/bufbuild/protocompile/parser"
 	"github.com/bufbuild/protocompile/reporter"
 	"github.com/jhump/protoreflect/v2/protobuilder"
+	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/structpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -29,8 +33,25 @@ import (
 	// "github.com/ryboe/q"
 	// "google.golang.org/genproto/googleapis/type/expr"
 	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/genproto/googleapis/type/datetime"
 	"google.golang.org/genproto/googleapis/type/decimal"
 	"google.golang.org/genproto/googleapis/type/money"
+	"google.golang.org/genproto/googleapis/type/timeofday"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	// Linked so -- replace: can target them by full name.
+	_ "google.golang.org/genproto/googleapis/type/color"
+	_ "google.golang.org/genproto/googleapis/type/dayofweek"
+	_ "google.golang.org/genproto/googleapis/type/fraction"
+	_ "google.golang.org/genproto/googleapis/type/interval"
+	_ "google.golang.org/genproto/googleapis/type/latlng"
+	_ "google.golang.org/genproto/googleapis/type/localized_text"
+	_ "google.golang.org/genproto/googleapis/type/month"
+	_ "google.golang.org/genproto/googleapis/type/phone_number"
+	_ "google.golang.org/genproto/googleapis/type/postaladdress"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
 	"github.com/jhump/protoreflect/v2/protoprint"
 
@@ -42,6 +63,18 @@ var (
 	DEFAULT_USER_DEFINED_DIR = "./user_defined"
 	DEFAULT_DEFAULT_PACKAGE  = "sqlcgen"
 	DEFAULT_ONE_OF_ID        = "identifier"
+	DEFAULT_LOCK_FILE        = "sqlc-gen-proto.lock"
+	TYPE_MAPPING_LEGACY      = "legacy"
+	TYPE_MAPPING_FAITHFUL    = "faithful"
+	NULLABLE_STYLE_WRAPPERS  = "wrappers"
+	NULLABLE_STYLE_OPTIONAL  = "optional"
+	NULLABLE_STYLE_NONE      = "none"
+	JSON_TYPE_VALUE          = WellKnownValue
+	JSON_TYPE_STRUCT         = WellKnownStruct
+	JSON_TYPE_STRING         = "string"
+	JSON_TYPE_BYTES          = "bytes"
+	ENGINE_MYSQL             = "mysql"
+	ENGINE_SQLITE            = "sqlite"
 	SYNTAX_PROTO3            = "proto3"
 	DO_NOT_GENERATE          = "DO_NOT_GENERATE"
 
@@ -53,6 +86,49 @@ type options struct {
 	UserDefinedDir string `json:"user_defined_dir,omitempty" yaml:"user_defined_dir"`
 	OneOfID        string `json:"one_of_id,omitempty"        yaml:"one_of_id"`
 	DefaultPackage string `json:"default_package,omitempty"  yaml:"default_package"`
+	// Legacy: write directly to out_dir instead of returning files to sqlc.
+	WriteToDisk bool `json:"write_to_disk,omitempty" yaml:"write_to_disk"`
+	// Defaults to sqlc-gen-proto.lock next to out_dir.
+	LockFile string `json:"lock_file,omitempty" yaml:"lock_file"`
+	// Generate even if the protos in out_dir would break.
+	AllowBreaking bool `json:"allow_breaking,omitempty" yaml:"allow_breaking"`
+	// Applied before the built-in type conversion.
+	TypeOverrides []typeOverride `json:"type_overrides,omitempty" yaml:"type_overrides"`
+	// legacy or faithful, faithful keeps numeric and temporal precision.
+	TypeMapping string `json:"type_mapping,omitempty" yaml:"type_mapping"`
+	// wrappers, optional or none, how nullable scalar columns are generated.
+	NullableStyle string `json:"nullable_style,omitempty" yaml:"nullable_style"`
+	// Type of json and jsonb, defaults to Struct for json and bytes for jsonb.
+	JSONType string `json:"json_type,omitempty" yaml:"json_type"`
+	// CREATE DOMAIN name -> base type, sqlc does not pass domains to plugins.
+	Domains map[string]string `json:"domains,omitempty" yaml:"domains"`
+
+	engine string // sqlc's engine, selects the type conversion
+}
+
+// typeOverride mirrors sqlc's overrides.  DBType matches only columns
+// with the same nullability, Column ("table.column" or
+// "schema.table.column") matches regardless of it.
+type typeOverride struct {
+	DBType      string `json:"db_type,omitempty"      yaml:"db_type"`
+	Column      string `json:"column,omitempty"       yaml:"column"`
+	Nullable    bool   `json:"nullable,omitempty"     yaml:"nullable"`
+	ProtoType   string `json:"proto_type"             yaml:"proto_type"`
+	ProtoImport string `json:"proto_import,omitempty" yaml:"proto_import"`
+}
+
+func (o typeOverride) matches(c *plugin.Column, table string) bool {
+	if o.Column != "" {
+		name := fmt.Sprintf("%s.%s", table, c.Name)
+		return o.Column == name || strings.HasSuffix(o.Column, "."+name)
+	}
+	if o.Nullable == c.NotNull {
+		return false
+	}
+	dbType := strings.ToLower(sdk.DataType(c.Type))
+	want := strings.ToLower(o.DBType)
+	return dbType == want ||
+		strings.TrimPrefix(dbType, "pg_catalog.") == strings.TrimPrefix(want, "pg_catalog.")
 }
 
 func getGenRequest() (*plugin.GenerateRequest, error) {
@@ -69,12 +145,23 @@ func getGenRequest() (*plugin.GenerateRequest, error) {
 }
 
 func parseOptions(req *plugin.GenerateRequest) (*options, error) {
-	var options *options
-	if len(req.PluginOptions) == 0 {
-		return options, nil
+	options := &options{}
+	if len(req.PluginOptions) > 0 {
+		if err := json.Unmarshal(req.PluginOptions, options); err != nil {
+			return nil, err
+		}
 	}
-	if err := json.Unmarshal(req.PluginOptions, &options); err != nil {
-		return nil, err
+	// sqlc writes returned files into the codegen out directory, out_dir
+	// must agree with it or the lock and breaking check look elsewhere.
+	if out := req.GetSettings().GetCodegen().GetOut(); out != "" && !options.WriteToDisk {
+		if options.OutDir != "" && filepath.Clean(options.OutDir) != filepath.Clean(out) {
+			return nil, fmt.Errorf(
+				"out_dir: %q must match the codegen out %q unless write_to_disk is set",
+				options.OutDir,
+				out,
+			)
+		}
+		options.OutDir = out
 	}
 	if options.OutDir == "" {
 		options.OutDir = DEFAULT_OUTDIR
@@ -88,6 +175,71 @@ func parseOptions(req *plugin.GenerateRequest) (*options, error) {
 	if options.DefaultPackage == "" {
 		options.DefaultPackage = DEFAULT_DEFAULT_PACKAGE
 	}
+	switch options.TypeMapping {
+	case "":
+		options.TypeMapping = TYPE_MAPPING_LEGACY
+	case TYPE_MAPPING_LEGACY, TYPE_MAPPING_FAITHFUL:
+	default:
+		return nil, fmt.Errorf(
+			"type_mapping: %q must be %q or %q",
+			options.TypeMapping,
+			TYPE_MAPPING_LEGACY,
+			TYPE_MAPPING_FAITHFUL,
+		)
+	}
+	switch options.NullableStyle {
+	case "":
+		options.NullableStyle = NULLABLE_STYLE_WRAPPERS
+	case NULLABLE_STYLE_WRAPPERS, NULLABLE_STYLE_OPTIONAL, NULLABLE_STYLE_NONE:
+	default:
+		return nil, fmt.Errorf(
+			"nullable_style: %q must be %q, %q or %q",
+			options.NullableStyle,
+			NULLABLE_STYLE_WRAPPERS,
+			NULLABLE_STYLE_OPTIONAL,
+			NULLABLE_STYLE_NONE,
+		)
+	}
+	switch options.JSONType {
+	case "", JSON_TYPE_VALUE, JSON_TYPE_STRUCT, JSON_TYPE_STRING, JSON_TYPE_BYTES:
+	default:
+		return nil, fmt.Errorf(
+			"json_type: %q must be %q, %q, %q or %q",
+			options.JSONType,
+			JSON_TYPE_VALUE,
+			JSON_TYPE_STRUCT,
+			JSON_TYPE_STRING,
+			JSON_TYPE_BYTES,
+		)
+	}
+	for i, o := range options.TypeOverrides {
+		if o.ProtoType == "" {
+			return nil, fmt.Errorf("type_overrides[%d]: proto_type is required", i)
+		}
+		if (o.DBType == "") == (o.Column == "") {
+			return nil, fmt.Errorf("type_overrides[%d]: exactly one of db_type or column is required", i)
+		}
+	}
+	domains := make(map[string]string, len(options.Domains))
+	for name, base := range options.Domains {
+		if base == "" {
+			return nil, fmt.Errorf("domains: %q: base type is required", name)
+		}
+		// Keyed without the schema, the same as columns are looked up.
+		dn := strings.ToLower(name[strings.LastIndex(name, ".")+1:])
+		if other, ok := domains[dn]; ok && other != strings.ToLower(base) {
+			return nil, fmt.Errorf("domains: %q: defined twice with different base types", dn)
+		}
+		domains[dn] = strings.ToLower(base)
+	}
+	options.Domains = domains
+	options.engine = req.GetSettings().GetEngine()
+	if options.LockFile == "" {
+		options.LockFile = filepath.Join(
+			filepath.Dir(filepath.Clean(options.OutDir)),
+			DEFAULT_LOCK_FILE,
+		)
+	}
 
 	DEFAULT_OUTDIR = options.OutDir
 	DEFAULT_DEFAULT_PACKAGE = options.DefaultPackage
@@ -113,9 +265,18 @@ func main() {
 		enums:   make([]*enum, 0),
 		queries: make([]*query, 0),
 		options: opts,
+		imports: new(protoregistry.Files),
 	}
 
-	if err := p.run(req); err != nil {
+	resp, err := p.run(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	respBlob, err := proto.Marshal(resp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := os.Stdout.Write(respBlob); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -168,11 +329,16 @@ func (pf Protofiles) Less(i, j int) bool {
 // map[ $outdir/$package/$filename]
 // map["./sqlcgen/foo/bar/baz/v1/message.proto"]
 type Protos struct {
-	queries []*query
-	tables  []*table
-	enums   []*enum
-	files   map[fpath]*protobuilder.FileBuilder
-	options *options
+	queries    []*query
+	tables     []*table
+	enums      []*enum
+	composites []*composite
+	files      map[fpath]*protobuilder.FileBuilder
+	// Every enum in the catalog by name, generated or not.
+	catalogEnums map[string]*plugin.Enum
+	options      *options
+	lock         *Lock
+	imports      *protoregistry.Files // Files loaded for type_overrides proto_import
 }
 
 func handleSkip(s string, skips []string) bool {
@@ -184,24 +350,37 @@ func handleSkip(s string, skips []string) bool {
 	return false
 }
 
-func copyAnnotations(t *table) error {
-	if t.a.ReqResp != nil {
-		t.a.ReqResp.a = &Annotations{
-			Generate: t.a.Generate,
-			Package:  t.a.Package,
-			OutDir:   t.a.OutDir,
+// contains reports if s is one of list.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func copyAnnotations(a *Annotations) error {
+	if a.ReqResp != nil {
+		a.ReqResp.a = &Annotations{
+			Generate: a.Generate,
+			Package:  a.Package,
+			OutDir:   a.OutDir,
 		}
-		if err := setProps(t.a.ReqResp); err != nil {
+		if err := setProps(a.ReqResp); err != nil {
 			return err
 		}
 	}
-	if t.a.Service != nil {
-		t.a.Service.a = &Annotations{
-			Generate: t.a.Generate,
-			Package:  t.a.Package,
-			OutDir:   t.a.OutDir,
+	for _, svc := range []*Service{a.Service, a.RPC} {
+		if svc == nil {
+			continue
 		}
-		if err := setProps(t.a.Service); err != nil {
+		svc.a = &Annotations{
+			Generate: a.Generate,
+			Package:  a.Package,
+			OutDir:   a.OutDir,
+		}
+		if err := setProps(svc); err != nil {
 			return err
 		}
 	}
@@ -218,12 +397,30 @@ func toResponseName(method, msgName string) string {
 
 func toHttpRule(method string, t *table) *annotations.HttpRule {
 	var httpRule *annotations.HttpRule
-	identifier := t.a.PrimaryKey
+	// Ex: {uuid} or {user_id}/{group_id}
+	var keys []string
+	for _, pk := range t.a.PrimaryKeys {
+		keys = append(keys, fmt.Sprintf("{%s}", pk))
+	}
+	identifier := strings.Join(keys, "/")
 
 	// POST, LIST
 	p := t.a.Service.Path.Path
+	if t.a.ReqResp != nil && t.a.ReqResp.Parent {
+		p = t.a.Service.parentPath()
+	}
 	// GET, UPDATE, DELETE
-	gp := fmt.Sprintf("%s/{%s}", p, identifier)
+	gp := fmt.Sprintf("%s/%s", p, identifier)
+	rName := *toLowerSnake(*toPascal(t.i.Rel.Name))
+	body := "*"
+	up := gp
+	// Ex: /v1/{name=users/*} and /v1/{users.name=users/*}
+	if r := t.a.Resource; r != nil {
+		prefix := r.prefix(t.a.Service.Path.Path)
+		gp = fmt.Sprintf("%s/{name=%s}", prefix, r.wildcard())
+		up = fmt.Sprintf("%s/{%s.name=%s}", prefix, rName, r.wildcard())
+		body = rName
+	}
 
 	switch method {
 	case "Create":
@@ -233,7 +430,7 @@ func toHttpRule(method string, t *table) *annotations.HttpRule {
 			Pattern: &annotations.HttpRule_Post{
 				Post: p,
 			},
-			Body: "*",
+			Body: body,
 		}
 	case "Get":
 		// Get are always GET with path + primarykey,
@@ -250,11 +447,21 @@ func toHttpRule(method string, t *table) *annotations.HttpRule {
 		// OneOf must have oneof the primary key.
 		// Connect cann't use a oneofs value.
 		// Ex: /v1/users/{uuid}
+		// With an update_mask it's a PATCH of the resource, AIP-134.
+		if t.a.ReqResp != nil && t.a.ReqResp.UpdateMask {
+			httpRule = &annotations.HttpRule{
+				Pattern: &annotations.HttpRule_Patch{
+					Patch: up,
+				},
+				Body: rName,
+			}
+			break
+		}
 		httpRule = &annotations.HttpRule{
 			Pattern: &annotations.HttpRule_Put{
-				Put: gp,
+				Put: up,
 			},
-			Body: "*",
+			Body: body,
 		}
 	case "Delete":
 		// Delete are always DELETE with path + primarykey,
@@ -281,6 +488,27 @@ func toHttpRule(method string, t *table) *annotations.HttpRule {
 	return httpRule
 }
 
+// toQueryHttpRule reads with GET and writes with POST.
+// Ex: /v1/users:search
+func toQueryHttpRule(q *query) *annotations.HttpRule {
+	p := q.a.RPC.Path.Path
+	switch q.i.Cmd {
+	case ":one", ":many":
+		return &annotations.HttpRule{
+			Pattern: &annotations.HttpRule_Get{
+				Get: p,
+			},
+		}
+	default:
+		return &annotations.HttpRule{
+			Pattern: &annotations.HttpRule_Post{
+				Post: p,
+			},
+			Body: "*",
+		}
+	}
+}
+
 func (p Protos) createServices(
 	mName string,
 	rrMap map[methodname]*protobuilder.MessageBuilder,
@@ -299,7 +527,7 @@ func (p Protos) createServices(
 			}
 		}()
 	}
-	for _, method := range METHOD_NAMES {
+	for _, method := range t.methods() {
 		req := rrMap[methodname(toRequestName(method, mName))]
 		resp := rrMap[methodname(toResponseName(method, mName))]
 
@@ -346,13 +574,27 @@ func (p Protos) createRequestResponses(
 	rrfb := p.getFD(t.a.ReqResp.a)
 
 	reqrespMap := make(map[methodname]*protobuilder.MessageBuilder)
-	for _, method := range METHOD_NAMES {
+	for _, method := range t.methods() {
 		reqName := toRequestName(method, mName)
 		respName := toResponseName(method, mName)
 
 		reqb := protobuilder.NewMessage(protoreflect.Name(reqName))
 		respb := protobuilder.NewMessage(protoreflect.Name(respName))
 
+		// Add the -- service: path parameters, a resource name already
+		// holds them.
+		if t.a.Service != nil && (t.a.Resource == nil || method == "Create" || method == "List") {
+			pbs, err := p.pathFields(t)
+			if err != nil {
+				return nil, err
+			}
+			for _, pb := range pbs {
+				if err := reqb.TryAddField(pb); err != nil {
+					return nil, err
+				}
+			}
+		}
+
 		// Add Annotedated Additional Fields
 		for aType, aField := range t.a.ReqResp.ReqFields {
 			at, err := p.convertType(aType)
@@ -385,7 +627,41 @@ func (p Protos) createRequestResponses(
 			}
 		}
 
-		if method == "Get" || method == "Update" || method == "Delete" {
+		switch {
+		case t.a.Resource != nil && (method == "Get" || method == "Delete"):
+			// The resource name replaces the oneof, the resource of an
+			// Update carries its own name.
+			nb := protobuilder.NewField("name", protobuilder.FieldTypeString())
+			fieldOptions := &descriptorpb.FieldOptions{}
+			proto.SetExtension(fieldOptions, annotations.E_FieldBehavior, []annotations.FieldBehavior{
+				annotations.FieldBehavior_REQUIRED,
+			})
+			proto.SetExtension(fieldOptions, annotations.E_ResourceReference, &annotations.ResourceReference{
+				Type: t.a.Resource.Type,
+			})
+			nb.SetOptions(fieldOptions)
+			if err := reqb.TryAddField(nb); err != nil {
+				return nil, err
+			}
+		case t.a.Resource != nil:
+		case len(t.a.PrimaryKeys) > 1 && (method == "Get" || method == "Update" || method == "Delete"):
+			// A oneof holds a single key, every column of a composite
+			// key is a required field, the path binds each of them.
+			for _, pk := range t.a.PrimaryKeys {
+				kb := messageb.GetField(protoreflect.Name(pk))
+				if kb == nil {
+					return nil, fmt.Errorf("%s.%s: primary key is not a field of %s", t.i.Rel.Name, pk, mName)
+				}
+				fieldOptions := &descriptorpb.FieldOptions{}
+				proto.SetExtension(fieldOptions, annotations.E_FieldBehavior, []annotations.FieldBehavior{
+					annotations.FieldBehavior_REQUIRED,
+				})
+				fb := protobuilder.NewField(kb.Name(), kb.Type()).SetOptions(fieldOptions)
+				if err := reqb.TryAddField(fb); err != nil {
+					return nil, err
+				}
+			}
+		case method == "Get" || method == "Update" || method == "Delete":
 			if err := reqb.TryAddOneOf(oneof); err != nil {
 				return nil, err
 			}
@@ -402,6 +678,19 @@ func (p Protos) createRequestResponses(
 			}
 		}
 
+		// AIP-134, the fields of the resource to update.
+		if method == "Update" && t.a.ReqResp.UpdateMask {
+			umb := protobuilder.NewField(
+				protoreflect.Name("update_mask"),
+				protobuilder.FieldTypeImportedMessage(
+					(*fieldmaskpb.FieldMask)(nil).ProtoReflect().Descriptor(),
+				),
+			)
+			if err := reqb.TryAddField(umb); err != nil {
+				return nil, err
+			}
+		}
+
 		if method == "List" {
 			psb := protobuilder.NewField(
 				protoreflect.Name("page_size"),
@@ -461,29 +750,136 @@ func (p Protos) createRequestResponses(
 	return reqrespMap, nil
 }
 
+// pathFields are the request fields bound to the -- service: path
+// parameters, ex: org and project for /v1/orgs/{org}/projects/{project}/users.
+// With -- request_response: parent they are a single parent field.
+func (p Protos) pathFields(t *table) ([]*protobuilder.FieldBuilder, error) {
+	params := t.a.Service.params()
+	if t.a.ReqResp != nil && t.a.ReqResp.Parent && len(params) > 0 {
+		params = []string{"parent"}
+	}
+
+	var fields []*protobuilder.FieldBuilder
+	for _, param := range params {
+		// -- request_response: req_field takes precedence, the path binds
+		// it so it must still be a string.
+		declared := false
+		if t.a.ReqResp != nil {
+			for aType, aField := range t.a.ReqResp.ReqFields {
+				if aField != param {
+					continue
+				}
+				ft, err := p.convertType(aType)
+				if err != nil {
+					return nil, err
+				}
+				if ft.Kind() != protoreflect.StringKind {
+					return nil, fmt.Errorf(
+						"-- request_response: req_field %s %s: path parameter {%s} must be a string",
+						aType, aField, param,
+					)
+				}
+				declared = true
+			}
+		}
+		if declared {
+			continue
+		}
+		fb := protobuilder.NewField(protoreflect.Name(param), protobuilder.FieldTypeString())
+		fieldOptions := &descriptorpb.FieldOptions{}
+		proto.SetExtension(fieldOptions, annotations.E_FieldBehavior, []annotations.FieldBehavior{
+			annotations.FieldBehavior_REQUIRED,
+		})
+		if param == "parent" && t.a.Resource != nil {
+			proto.SetExtension(fieldOptions, annotations.E_ResourceReference, &annotations.ResourceReference{
+				ChildType: t.a.Resource.Type,
+			})
+		}
+		fb.SetOptions(fieldOptions)
+		fields = append(fields, fb)
+	}
+	return fields, nil
+}
+
 func (p Protos) tableToMessage(
 	fileb *protobuilder.FileBuilder,
 	t *table,
 ) error {
 	mName := *toPascal(t.i.Rel.Name)
-	messageb := protobuilder.NewMessage(protoreflect.Name(mName))
+	messageb := fileb.GetMessage(protoreflect.Name(mName))
+	if messageb == nil {
+		return fmt.Errorf("%s: message was not declared", t.i.Rel.Name)
+	}
+	// Collected from the columns below.
+	t.a.PrimaryKeys = nil
+
+	for r := range t.a.Replace {
+		found := false
+		for _, c := range t.i.Columns {
+			found = found || c.Name == r
+		}
+		if !found {
+			return fmt.Errorf("%s.%s: -- replace: column not found", t.i.Rel.Name, r)
+		}
+	}
+	for j := range t.a.JSON {
+		found := false
+		for _, c := range t.i.Columns {
+			found = found || c.Name == j
+		}
+		if !found {
+			return fmt.Errorf("%s.%s: -- json: column not found", t.i.Rel.Name, j)
+		}
+	}
+	for b := range t.a.Behaviors {
+		found := false
+		for _, c := range t.i.Columns {
+			found = found || c.Name == b
+		}
+		if !found {
+			return fmt.Errorf("%s.%s: -- behavior: column not found", t.i.Rel.Name, b)
+		}
+	}
+
+	// AIP-122, the resource name is the first field.
+	if t.a.Resource != nil {
+		for _, c := range t.i.Columns {
+			if c.Name == "name" && !handleSkip(c.Name, t.a.Skips) {
+				return fmt.Errorf(
+					"%s.%s: -- resource: column conflicts with the resource name field",
+					t.i.Rel.Name, c.Name,
+				)
+			}
+		}
+		nb := protobuilder.NewField("name", protobuilder.FieldTypeString())
+		fieldOptions := &descriptorpb.FieldOptions{}
+		proto.SetExtension(fieldOptions, annotations.E_FieldBehavior, []annotations.FieldBehavior{
+			annotations.FieldBehavior_IDENTIFIER,
+		})
+		nb.SetOptions(fieldOptions)
+		if err := messageb.TryAddField(nb); err != nil {
+			return err
+		}
+		messageOptions := &descriptorpb.MessageOptions{}
+		proto.SetExtension(messageOptions, annotations.E_Resource, t.a.Resource.descriptor())
+		messageb.SetOptions(messageOptions)
+	}
 
 	for _, c := range t.i.Columns {
 		if handleSkip(c.Name, t.a.Skips) {
 			continue
 		}
 		if c.PrimaryKey {
-			t.a.PrimaryKey = c.Name
+			t.a.PrimaryKeys = append(t.a.PrimaryKeys, c.Name)
 		}
-		cName := protoreflect.Name(c.Name)
-		t, err := p.convertType(c)
+		fieldb, err := p.columnField(protoreflect.Name(c.Name), c, t.a, t.i.Rel.Name)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s.%s: %w", t.i.Rel.Name, c.Name, err)
 		}
-
-		fieldb := protobuilder.NewField(cName, t)
-		if c.IsArray {
-			fieldb.SetRepeated()
+		if behaviors := fieldBehaviors(c, t.a); len(behaviors) > 0 {
+			fieldOptions := &descriptorpb.FieldOptions{}
+			proto.SetExtension(fieldOptions, annotations.E_FieldBehavior, behaviors)
+			fieldb.SetOptions(fieldOptions)
 		}
 
 		if err := messageb.TryAddField(fieldb); err != nil {
@@ -491,13 +887,9 @@ func (p Protos) tableToMessage(
 		}
 	}
 
-	if err := fileb.TryAddMessage(messageb); err != nil {
-		return err
-	}
-
 	// Copy over Annotations Into New Pointer
 	// and Set Properties for ReqResp type.
-	if err := copyAnnotations(t); err != nil {
+	if err := copyAnnotations(t.a); err != nil {
 		return err
 	}
 	// Handle request_response
@@ -520,6 +912,30 @@ func (p Protos) tableToMessage(
 	return nil
 }
 
+// fieldBehaviors returns google.api.field_behavior for a table column, only
+// the primary key is inferred as IMMUTABLE.  sqlc does not pass column
+// defaults or generated columns to plugins, so a NOT NULL column may still
+// be server populated, REQUIRED and OUTPUT_ONLY come from -- behavior:
+// which replaces the inference.
+func fieldBehaviors(c *plugin.Column, a *Annotations) []annotations.FieldBehavior {
+	if annotated, ok := a.Behaviors[c.Name]; ok {
+		var behaviors []annotations.FieldBehavior
+		for _, b := range annotated {
+			// -- behavior: <column> FIELD_BEHAVIOR_UNSPECIFIED drops the inference.
+			if b != annotations.FieldBehavior_FIELD_BEHAVIOR_UNSPECIFIED {
+				behaviors = append(behaviors, b)
+			}
+		}
+		return behaviors
+	}
+
+	if c.PrimaryKey {
+		return []annotations.FieldBehavior{annotations.FieldBehavior_IMMUTABLE}
+	}
+
+	return nil
+}
+
 func (p Protos) queryToMessage(
 	messageb *protobuilder.MessageBuilder,
 	q *query,
@@ -531,13 +947,27 @@ func (p Protos) queryToMessage(
 		// Append missing Columns from queries to Target
 		cName := protoreflect.Name(c.Name)
 		if messageb.GetField(cName) == nil {
-			t, err := p.convertType(c)
-			if err != nil {
-				return err
+			// sqlc.embed(table) nests the table's message
+			if c.EmbedTable != nil {
+				eName := protoreflect.Name(*toPascal(c.EmbedTable.Name))
+				eb, err := p.GetMessage(eName)
+				if err != nil {
+					return fmt.Errorf(
+						"%s: sqlc.embed(%s) requires the table to be generated: %w",
+						q.i.Name,
+						c.EmbedTable.Name,
+						err,
+					)
+				}
+				fieldb := protobuilder.NewField(cName, protobuilder.FieldTypeMessage(eb))
+				if err := messageb.TryAddField(fieldb); err != nil {
+					return err
+				}
+				continue
 			}
-			fieldb := protobuilder.NewField(cName, t)
-			if c.IsArray {
-				fieldb.SetRepeated()
+			fieldb, err := p.columnField(cName, c, q.a, columnTable(c))
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", q.i.Name, c.Name, err)
 			}
 			if err := messageb.TryAddField(fieldb); err != nil {
 				return err
@@ -561,12 +991,60 @@ func (p Protos) getFD(a *Annotations) *protobuilder.FileBuilder {
 	return p.files[op]
 }
 
+// Composites creates a message per composite type before the tables so
+// columns of that type resolve to it.  sqlc's catalog does not carry the
+// attributes of a composite, its fields come from the matching message
+// in user_defined_dir.
+func (p Protos) Composites() error {
+	for _, c := range p.composites {
+		fb := p.getFD(c.a)
+		mName := protoreflect.Name(*toPascal(c.i.Name))
+		if fb.GetMessage(mName) != nil {
+			continue
+		}
+		if err := fb.TryAddMessage(protobuilder.NewMessage(mName)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckComposites runs after UserDefined, a composite left without fields
+// had no matching message in user_defined_dir and would be generated empty.
+func (p Protos) CheckComposites() error {
+	for _, c := range p.composites {
+		fb := p.getFD(c.a)
+		mName := *toPascal(c.i.Name)
+		mb := fb.GetMessage(protoreflect.Name(mName))
+		if mb == nil || len(messageFields(mb)) > 0 {
+			continue
+		}
+		ud := fmt.Sprintf("%s/%s", p.options.UserDefinedDir, fb.Path())
+		for _, t := range p.tables {
+			for _, col := range t.i.Columns {
+				// Ex: public.address
+				ct := filepath.Base(pkgToPath(strings.ToLower(sdk.DataType(col.Type))))
+				if *toPascal(ct) == mName {
+					return fmt.Errorf("%s.%s: composite type %s has no fields, declare them in %s", t.i.Rel.Name, col.Name, c.i.Name, ud)
+				}
+			}
+		}
+		return fmt.Errorf("%s: composite type has no fields, declare them in %s", c.i.Name, ud)
+	}
+
+	return nil
+}
+
 // Responsible for Constructing message.proto
-func (p Protos) Messages() error {
+// DeclareMessages adds an empty message for every table before any field
+// is built, so -- replace: can point at a table's message regardless of
+// the order tables are generated in.  tableToMessage fills them in.
+func (p Protos) DeclareMessages() error {
 	for _, t := range p.tables {
 		fb := p.getFD(t.a)
-
-		if err := p.tableToMessage(fb, t); err != nil {
+		mb := protobuilder.NewMessage(protoreflect.Name(*toPascal(t.i.Rel.Name)))
+		if err := fb.TryAddMessage(mb); err != nil {
 			return err
 		}
 	}
@@ -574,13 +1052,11 @@ func (p Protos) Messages() error {
 	return nil
 }
 
-func (p Protos) Queries() error {
-	for _, q := range p.queries {
-		mb, err := p.GetMessage(protoreflect.Name(*toPascal(q.a.Target)))
-		if err != nil {
-			return err
-		}
-		if err := p.queryToMessage(mb, q); err != nil {
+func (p Protos) Messages() error {
+	for _, t := range p.tables {
+		fb := p.getFD(t.a)
+
+		if err := p.tableToMessage(fb, t); err != nil {
 			return err
 		}
 	}
@@ -588,83 +1064,315 @@ func (p Protos) Queries() error {
 	return nil
 }
 
-func (p Protos) appendFieldsToMessage(
-	desc *descriptorpb.DescriptorProto,
-	b *protobuilder.MessageBuilder,
-) error {
-	for _, uf := range desc.GetField() {
-		var fType *protobuilder.FieldType
-		if b.GetField(protoreflect.Name(*uf.Name)) != nil {
-			continue
+func (p Protos) Queries() error {
+	for _, q := range p.queries {
+		if err := checkQueryColumns(q); err != nil {
+			return err
 		}
-		if uf.Type != nil {
-			ft, err := p.convertType(uf.Type.String())
+		if q.a.Target != "" {
+			mb, err := p.GetMessage(protoreflect.Name(*toPascal(q.a.Target)))
 			if err != nil {
 				return err
 			}
-			fType = ft
-		} else {
-			if uf.TypeName != nil {
-				ft, err := p.convertType(userDefinedString(uf.TypeName))
-				if err != nil {
-					return err
-				}
-				fType = ft
+			if err := p.queryToMessage(mb, q); err != nil {
+				return err
+			}
+		}
+		// Without a target the columns become <QueryName>Row
+		if q.a.Target == "" && len(q.i.Columns) > 0 {
+			if _, err := p.queryRow(q); err != nil {
+				return err
+			}
+		}
+		if len(q.i.Params) > 0 {
+			if err := p.queryToParams(q); err != nil {
+				return err
+			}
+		}
+		if q.a.RPC != nil {
+			if err := p.queryToRPC(q); err != nil {
+				return err
 			}
 		}
-		b.AddField(protobuilder.NewField(
-			protoreflect.Name(*uf.Name),
-			fType,
-		))
 	}
 
 	return nil
 }
 
-func (p Protos) appendMethodsToService(
-	desc *descriptorpb.ServiceDescriptorProto,
-	b *protobuilder.ServiceBuilder,
-) error {
-	for _, mm := range desc.GetMethod() {
-		if b.GetMethod(protoreflect.Name(*mm.Name)) != nil {
-			continue
-		}
-		itb, err := p.GetMessage(protoreflect.Name(*mm.InputType))
-		if err != nil {
-			return err
-		}
-		otb, err := p.GetMessage(protoreflect.Name(*mm.OutputType))
-		if err != nil {
-			return err
-		}
-		reqb := protobuilder.RpcTypeMessage(itb, false)
-		respb := protobuilder.RpcTypeMessage(otb, false)
+// queryToParams creates <QueryName>Params next to the query's messages.
+func (p Protos) queryToParams(q *query) error {
+	fb := p.getFD(q.a)
+	pName := protoreflect.Name(fmt.Sprintf("%sParams", *toPascal(q.i.Name)))
+	paramsb := protobuilder.NewMessage(pName)
+	if err := p.paramsToMessage(paramsb, q); err != nil {
+		return err
+	}
 
-		nmb := protobuilder.NewMethod(protoreflect.Name(*mm.Name), reqb, respb)
-		nmb.SetOptions(mm.Options)
+	return fb.TryAddMessage(paramsb)
+}
 
-		if err := b.TryAddMethod(nmb); err != nil {
-			return err
+// checkQueryColumns fails on -- replace: and -- json: columns that are
+// neither a column nor a parameter of the query.
+func checkQueryColumns(q *query) error {
+	names := make(map[string]bool)
+	for _, c := range q.i.Columns {
+		names[c.Name] = true
+	}
+	for _, param := range q.i.Params {
+		names[param.Column.Name] = true
+	}
+	for r := range q.a.Replace {
+		if !names[r] {
+			return fmt.Errorf("%s.%s: -- replace: column not found", q.i.Name, r)
+		}
+	}
+	for j := range q.a.JSON {
+		if !names[j] {
+			return fmt.Errorf("%s.%s: -- json: column not found", q.i.Name, j)
 		}
 	}
 
 	return nil
 }
 
-func (p Protos) appendValuesToEnum(
-	desc *descriptorpb.EnumDescriptorProto,
-	b *protobuilder.EnumBuilder,
+// paramName mirrors sqlc, unnamed parameters become dollar_N
+func paramName(param *plugin.Parameter) string {
+	if param.Column.Name != "" {
+		return param.Column.Name
+	}
+	return fmt.Sprintf("dollar_%d", param.Number)
+}
+
+// paramsToMessage adds one field per query parameter.
+// sqlc.narg and nullable columns are not NotNull and become wrappers,
+// sqlc.slice becomes repeated.
+func (p Protos) paramsToMessage(
+	messageb *protobuilder.MessageBuilder,
+	q *query,
 ) error {
-	for _, em := range desc.GetValue() {
-		if b.GetValue(protoreflect.Name(*em.Name)) != nil {
+	for _, param := range q.i.Params {
+		pName := protoreflect.Name(paramName(param))
+		if messageb.GetField(pName) != nil {
 			continue
 		}
-		evb := protobuilder.NewEnumValue(protoreflect.Name(*em.Name))
-		if err := b.TryAddValue(evb); err != nil {
+		fieldb, err := p.columnField(pName, param.Column, q.a, columnTable(param.Column))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", q.i.Name, pName, err)
+		}
+		if err := messageb.TryAddField(fieldb); err != nil {
 			return err
 		}
 	}
-
+	return nil
+}
+
+// queryRow returns the message a query's rows are returned as,
+// the target message if declared or a new <QueryName>Row.
+func (p Protos) queryRow(q *query) (*protobuilder.MessageBuilder, error) {
+	if q.a.Target != "" {
+		return p.GetMessage(protoreflect.Name(*toPascal(q.a.Target)))
+	}
+
+	fb := p.getFD(q.a)
+	rName := protoreflect.Name(fmt.Sprintf("%sRow", *toPascal(q.i.Name)))
+	if rowb := fb.GetMessage(rName); rowb != nil {
+		return rowb, nil
+	}
+	rowb := protobuilder.NewMessage(rName)
+	if err := p.queryToMessage(rowb, q); err != nil {
+		return nil, err
+	}
+	if err := fb.TryAddMessage(rowb); err != nil {
+		return nil, err
+	}
+	return rowb, nil
+}
+
+// queryToRPC creates <QueryName>Request, <QueryName>Response and
+// an rpc <QueryName> shaped by the query's Cmd.
+func (p Protos) queryToRPC(q *query) error {
+	if err := copyAnnotations(q.a); err != nil {
+		return err
+	}
+	qName := *toPascal(q.i.Name)
+	rrfb := p.getFD(q.a.ReqResp.a)
+
+	reqb := protobuilder.NewMessage(protoreflect.Name(toRequestName("", qName)))
+	respb := protobuilder.NewMessage(protoreflect.Name(toResponseName("", qName)))
+
+	if err := p.paramsToMessage(reqb, q); err != nil {
+		return err
+	}
+
+	switch q.i.Cmd {
+	case ":one", ":batchone":
+		rowb, err := p.queryRow(q)
+		if err != nil {
+			return err
+		}
+		rName := "row"
+		if q.a.Target != "" {
+			rName = *toLowerSnake(string(rowb.Name()))
+		}
+		fb := protobuilder.NewField(
+			protoreflect.Name(rName),
+			protobuilder.FieldTypeMessage(rowb),
+		)
+		if err := respb.TryAddField(fb); err != nil {
+			return err
+		}
+	case ":many", ":batchmany":
+		// Pagination is up to the query's own parameters, ex: LIMIT
+		// sqlc.arg(page_size), there is nothing to bind a page token to.
+		rowb, err := p.queryRow(q)
+		if err != nil {
+			return err
+		}
+		rName := "rows"
+		if q.a.Target != "" {
+			rName = *toLowerSnake(string(rowb.Name()))
+		}
+		fb := protobuilder.NewField(
+			protoreflect.Name(rName),
+			protobuilder.FieldTypeMessage(rowb),
+		).SetRepeated()
+		if err := respb.TryAddField(fb); err != nil {
+			return err
+		}
+	case ":execrows", ":execresult", ":copyfrom":
+		fb := protobuilder.NewField(
+			protoreflect.Name("rows_affected"),
+			protobuilder.FieldTypeInt64(),
+		)
+		if err := respb.TryAddField(fb); err != nil {
+			return err
+		}
+	case ":execlastid":
+		fb := protobuilder.NewField(
+			protoreflect.Name("last_insert_id"),
+			protobuilder.FieldTypeInt64(),
+		)
+		if err := respb.TryAddField(fb); err != nil {
+			return err
+		}
+	case ":exec", ":batchexec":
+		// Empty Response
+	default:
+		return fmt.Errorf("%s: %q rpc generation not supported", q.i.Name, q.i.Cmd)
+	}
+
+	if err := rrfb.TryAddMessage(reqb); err != nil {
+		return err
+	}
+	if err := rrfb.TryAddMessage(respb); err != nil {
+		return err
+	}
+
+	svcfb := p.getFD(q.a.RPC.a)
+	sName := protoreflect.Name(*toPascal(q.a.RPC.Name))
+	sb := svcfb.GetService(sName)
+	if sb == nil {
+		sb = protobuilder.NewService(sName)
+		if err := svcfb.TryAddService(sb); err != nil {
+			return err
+		}
+	}
+
+	mb := protobuilder.NewMethod(
+		protoreflect.Name(qName),
+		protobuilder.RpcTypeMessage(reqb, false),
+		protobuilder.RpcTypeMessage(respb, false),
+	)
+	if q.a.RPC.Path != nil {
+		methodOptions := &descriptorpb.MethodOptions{}
+		proto.SetExtension(methodOptions, annotations.E_Http, toQueryHttpRule(q))
+		mb.SetOptions(methodOptions)
+	}
+
+	return sb.TryAddMethod(mb)
+}
+
+func (p Protos) appendFieldsToMessage(
+	desc *descriptorpb.DescriptorProto,
+	b *protobuilder.MessageBuilder,
+) error {
+	for _, uf := range desc.GetField() {
+		var fType *protobuilder.FieldType
+		if b.GetField(protoreflect.Name(*uf.Name)) != nil {
+			continue
+		}
+		if uf.Type != nil {
+			ft, err := p.convertType(uf.Type.String())
+			if err != nil {
+				return err
+			}
+			fType = ft
+		} else {
+			if uf.TypeName != nil {
+				ft, err := p.convertType(userDefinedString(uf.TypeName))
+				if err != nil {
+					return err
+				}
+				fType = ft
+			}
+		}
+		fieldb := protobuilder.NewField(
+			protoreflect.Name(*uf.Name),
+			fType,
+		)
+		if uf.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			fieldb.SetRepeated()
+		}
+		b.AddField(fieldb)
+	}
+
+	return nil
+}
+
+func (p Protos) appendMethodsToService(
+	desc *descriptorpb.ServiceDescriptorProto,
+	b *protobuilder.ServiceBuilder,
+) error {
+	for _, mm := range desc.GetMethod() {
+		if b.GetMethod(protoreflect.Name(*mm.Name)) != nil {
+			continue
+		}
+		itb, err := p.GetMessage(protoreflect.Name(*mm.InputType))
+		if err != nil {
+			return err
+		}
+		otb, err := p.GetMessage(protoreflect.Name(*mm.OutputType))
+		if err != nil {
+			return err
+		}
+		reqb := protobuilder.RpcTypeMessage(itb, false)
+		respb := protobuilder.RpcTypeMessage(otb, false)
+
+		nmb := protobuilder.NewMethod(protoreflect.Name(*mm.Name), reqb, respb)
+		nmb.SetOptions(mm.Options)
+
+		if err := b.TryAddMethod(nmb); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p Protos) appendValuesToEnum(
+	desc *descriptorpb.EnumDescriptorProto,
+	b *protobuilder.EnumBuilder,
+) error {
+	for _, em := range desc.GetValue() {
+		if b.GetValue(protoreflect.Name(*em.Name)) != nil {
+			continue
+		}
+		evb := protobuilder.NewEnumValue(protoreflect.Name(*em.Name))
+		if err := b.TryAddValue(evb); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -681,27 +1389,53 @@ func (p Protos) GetFiles() []*protobuilder.FileBuilder {
 	return filesSlice
 }
 
-func (p Protos) UserDefined() error {
+// DeclareUserDefined adds an empty message or enum for every one in
+// user_defined_dir that is not generated, so -- replace: can point at them
+// before UserDefined fills them in.
+func (p Protos) DeclareUserDefined() error {
 	for _, file := range p.GetFiles() {
 		mp := fmt.Sprintf("%s/%s", p.options.UserDefinedDir, file.Path())
 
 		if _, err := os.Stat(mp); err != nil {
 			continue
 		}
-		mFile, err := os.Open(mp)
+		udFile, err := parseProtoFile(mp)
 		if err != nil {
 			return err
 		}
-		filename := filepath.Base(mp)
-		node, err := parser.Parse(filename, mFile, reporter.NewHandler(nil))
-		if err != nil {
-			return err
+
+		for _, ue := range udFile.GetEnumType() {
+			if file.GetEnum(protoreflect.Name(ue.GetName())) != nil {
+				continue
+			}
+			if err := file.TryAddEnum(protobuilder.NewEnum(protoreflect.Name(ue.GetName()))); err != nil {
+				return err
+			}
+		}
+		for _, um := range udFile.GetMessageType() {
+			if file.GetMessage(protoreflect.Name(um.GetName())) != nil {
+				continue
+			}
+			if err := file.TryAddMessage(protobuilder.NewMessage(protoreflect.Name(um.GetName()))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p Protos) UserDefined() error {
+	for _, file := range p.GetFiles() {
+		mp := fmt.Sprintf("%s/%s", p.options.UserDefinedDir, file.Path())
+
+		if _, err := os.Stat(mp); err != nil {
+			continue
 		}
-		result, err := parser.ResultFromAST(node, false, reporter.NewHandler(nil))
+		udFile, err := parseProtoFile(mp)
 		if err != nil {
 			return err
 		}
-		udFile := result.FileDescriptorProto()
 
 		// Enums
 		for _, ue := range udFile.GetEnumType() {
@@ -764,6 +1498,31 @@ func (p Protos) UserDefined() error {
 	return nil
 }
 
+// parseProtoFile parses a .proto from disk without resolving imports,
+// options are left uninterpreted.
+func parseProtoFile(path string) (*descriptorpb.FileDescriptorProto, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseProto(filepath.Base(path), f)
+}
+
+func parseProto(filename string, r io.Reader) (*descriptorpb.FileDescriptorProto, error) {
+	node, err := parser.Parse(filename, r, reporter.NewHandler(nil))
+	if err != nil {
+		return nil, err
+	}
+	result, err := parser.ResultFromAST(node, false, reporter.NewHandler(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return result.FileDescriptorProto(), nil
+}
+
 func (p Protos) GetMessage(name protoreflect.Name) (*protobuilder.MessageBuilder, error) {
 	for _, file := range p.files {
 		m := file.GetMessage(name)
@@ -799,43 +1558,81 @@ func (p Protos) Enums() error {
 	return nil
 }
 
-func (p Protos) WriteFiles() error {
+// BuildFiles builds every FileBuilder into a FileDescriptor,
+// in the same order as GetFiles.
+func (p Protos) BuildFiles() ([]protoreflect.FileDescriptor, error) {
 	fdSlice := []protoreflect.FileDescriptor{}
-	printer := protoprint.Printer{}
-	for _, file := range p.files {
+	for _, file := range p.GetFiles() {
 		b, err := file.Build()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		fdSlice = append(fdSlice, b)
 	}
 
-	if err := os.MkdirAll(p.options.OutDir, os.ModePerm); err != nil {
-		return err
+	return fdSlice, nil
+}
+
+// GenerateFiles prints every FileDescriptor into memory so sqlc
+// can handle writing, diffing and vetting the output.
+func (p Protos) GenerateFiles() ([]*plugin.File, error) {
+	fdSlice, err := p.BuildFiles()
+	if err != nil {
+		return nil, err
 	}
-	if err := printer.PrintProtosToFileSystem(fdSlice, p.options.OutDir); err != nil {
-		return err
+
+	printer := protoprint.Printer{}
+	files := make([]*plugin.File, 0, len(fdSlice))
+	for _, fd := range fdSlice {
+		var buf bytes.Buffer
+		if err := printer.PrintProtoFile(fd, &buf); err != nil {
+			return nil, err
+		}
+		files = append(files, &plugin.File{
+			Name:     fd.Path(),
+			Contents: buf.Bytes(),
+		})
+	}
+
+	return files, nil
+}
+
+// WriteFiles is the legacy output mode, it writes straight into OutDir.
+func (p Protos) WriteFiles(files []*plugin.File) error {
+	for _, file := range files {
+		op := filepath.Join(p.options.OutDir, file.Name)
+		if err := os.MkdirAll(filepath.Dir(op), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(op, file.Contents, 0o644); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 type Annotations struct {
-	Generate bool   // All:   Generate Protos.
-	Package  string // All: Package Name.
-	// Replace  map[string]PType    // Tables -> Messages: Type Replacement
-	Skips    []string // Tables -> Messages: Skip Field
-	ReqResp  *ReqResp // Tables -> Messaes:  Information for generating Request and Responses
-	Service  *Service // Tables -> Messaes:  Information for generating Services
-	Target   string   // Applies only to querys
-	FileName string   // Override output filename
-	OutDir   string   // Override base output directory
+	Generate     bool                                   // All:   Generate Protos.
+	Package      string                                 // All: Package Name.
+	Replace      map[string]string                      // Tables -> Messages: Type Replacement
+	JSON         map[string]typeOverride                // Tables, Queries: json and jsonb column -> message
+	Behaviors    map[string][]annotations.FieldBehavior // Tables -> Messages: google.api.field_behavior
+	Skips        []string                               // Tables -> Messages: Skip Field
+	NullElements []string                               // Arrays whose elements may be NULL
+	ReqResp      *ReqResp                               // Tables -> Messaes:  Information for generating Request and Responses
+	Service      *Service                               // Tables -> Messaes:  Information for generating Services
+	Resource     *Resource                              // Tables -> Messages: google.api.resource type and pattern
+	RPC          *Service                               // Queries -> Services: Information for generating an rpc
+	Target       string                                 // Applies only to querys
+	FileName     string                                 // Override output filename
+	OutDir       string                                 // Override base output directory
 
 	FullPath     string // Generated from Package + FilenName
 	FullTypeName string // Generated from Package + FilenName
 	OutputPath   string // Generated from OutDir + FullPath
-	PrimaryKey   string
+	PrimaryKeys  []string
 }
 
 // enumWrapper attaches parsed comment Annotations for plugin.Enum
@@ -844,6 +1641,12 @@ type enum struct {
 	a *Annotations
 }
 
+// compositeWrapper attaches parsed comment Annotations to plugin.CompositeType
+type composite struct {
+	i *plugin.CompositeType
+	a *Annotations
+}
+
 // queryWrapper attaches parsed comment Annotations to plugin.Query
 type query struct {
 	i *plugin.Query
@@ -856,6 +1659,16 @@ type table struct {
 	a *Annotations
 }
 
+// methods are the request_response and service methods of a table, a
+// table without a primary key or resource name can't be addressed by Get,
+// Update and Delete.
+func (t *table) methods() []string {
+	if len(t.a.PrimaryKeys) == 0 && t.a.Resource == nil {
+		return []string{"Create", "List"}
+	}
+	return METHOD_NAMES
+}
+
 func wrapTable(i *plugin.Table) (*table, error) {
 	a, err := parseAnnotations(i.RawComments)
 	if err != nil {
@@ -887,6 +1700,21 @@ func wrapQuery(i *plugin.Query) (*query, error) {
 	return x, nil
 }
 
+func wrapComposite(i *plugin.CompositeType) (*composite, error) {
+	a, err := parseAnnotations(i.RawComments)
+	if err != nil {
+		return nil, err
+	}
+	x := &composite{
+		i: i,
+		a: a,
+	}
+	if err := setProps(x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
 func wrapEnum(i *plugin.Enum) (*enum, error) {
 	a, err := parseAnnotations(i.RawComments)
 	if err != nil {
@@ -902,10 +1730,11 @@ func wrapEnum(i *plugin.Enum) (*enum, error) {
 	return x, nil
 }
 
-func (p *Protos) run(req *plugin.GenerateRequest) error {
+func (p *Protos) run(req *plugin.GenerateRequest) (*plugin.GenerateResponse, error) {
 
 	schemas := req.GetCatalog().GetSchemas()
 	queries := req.GetQueries()
+	p.catalogEnums = make(map[string]*plugin.Enum)
 
 	for _, schema := range schemas {
 		if schema.Name == "pg_catalog" || schema.Name == "information_schema" {
@@ -918,20 +1747,31 @@ func (p *Protos) run(req *plugin.GenerateRequest) error {
 				if err.Error() == DO_NOT_GENERATE {
 					continue
 				}
-				return err
+				return nil, err
 			}
 			p.tables = append(p.tables, t)
 		}
 		for _, enum := range schema.GetEnums() {
+			p.catalogEnums[strings.ToLower(enum.Name)] = enum
 			e, err := wrapEnum(enum)
 			if err != nil {
 				if err.Error() == DO_NOT_GENERATE {
 					continue
 				}
-				return err
+				return nil, err
 			}
 			p.enums = append(p.enums, e)
 		}
+		for _, ct := range schema.GetCompositeTypes() {
+			c, err := wrapComposite(ct)
+			if err != nil {
+				if err.Error() == DO_NOT_GENERATE {
+					continue
+				}
+				return nil, err
+			}
+			p.composites = append(p.composites, c)
+		}
 	}
 
 	for _, query := range queries {
@@ -940,36 +1780,82 @@ func (p *Protos) run(req *plugin.GenerateRequest) error {
 			if err.Error() == DO_NOT_GENERATE {
 				continue
 			}
-			return err
+			return nil, err
 		}
 		p.queries = append(p.queries, q)
 	}
 
 	if err := p.Enums(); err != nil {
-		return err
+		return nil, err
+	}
+	if err := p.Composites(); err != nil {
+		return nil, err
+	}
+	if err := p.DeclareMessages(); err != nil {
+		return nil, err
+	}
+	if err := p.DeclareUserDefined(); err != nil {
+		return nil, err
 	}
 	if err := p.Messages(); err != nil {
-		return err
+		return nil, err
 	}
 	if err := p.Queries(); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := p.UserDefined(); err != nil {
-		return err
+		return nil, err
+	}
+	if err := p.CheckComposites(); err != nil {
+		return nil, err
 	}
 
-	if err := p.WriteFiles(); err != nil {
-		return err
+	if err := p.ApplyLock(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	files, err := p.GenerateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.CheckBreaking(files); err != nil {
+		return nil, err
+	}
+
+	if p.lock != nil {
+		p.lock.recordFiles(files)
+	}
+
+	// The lock is persisted wherever the protos are.
+	resp := &plugin.GenerateResponse{}
+	if p.options.WriteToDisk {
+		if err := p.WriteFiles(files); err != nil {
+			return nil, err
+		}
+		if err := p.WriteLock(); err != nil {
+			return nil, err
+		}
+	} else {
+		lockFile, err := p.LockFile()
+		if err != nil {
+			return nil, err
+		}
+		if lockFile != nil {
+			files = append(files, lockFile)
+		}
+		resp.Files = files
+	}
+
+	return resp, nil
 }
 
 func parseAnnotations(comments []string) (*Annotations, error) {
-	// replace := make(map[string]PType)
 	a := &Annotations{
-		// Replace: replace,
+		Replace:   make(map[string]string),
+		JSON:      make(map[string]typeOverride),
+		Behaviors: make(map[string][]annotations.FieldBehavior),
 	}
 
 	for _, line := range comments {
@@ -1012,11 +1898,16 @@ func parseAnnotations(comments []string) (*Annotations, error) {
 		for _, cmdOption := range []string{
 			"package",
 			"replace",
+			"json",
+			"behavior",
 			"filename",
 			"target",
 			"skip",
+			"nullable_elements",
 			"request_response",
 			"service",
+			"resource",
+			"rpc",
 		} {
 			if !strings.HasPrefix(strings.TrimSpace(rest), cmdOption) {
 				continue
@@ -1036,6 +1927,54 @@ func parseAnnotations(comments []string) (*Annotations, error) {
 				}
 				packageName := part[2]
 				a.Package = packageName
+			case "replace":
+				if len(part) != 4 {
+					return nil, fmt.Errorf(
+						"-- replace: <column> <proto type>... takes exactly 2 arguments",
+					)
+				}
+				a.Replace[part[2]] = part[3]
+			case "json":
+				if len(part) != 4 && len(part) != 5 {
+					return nil, fmt.Errorf(
+						"-- json: <column> <message> [proto import]... takes 2 or 3 arguments",
+					)
+				}
+				j := typeOverride{Column: part[2], ProtoType: part[3]}
+				if len(part) == 5 {
+					j.ProtoImport = part[4]
+				}
+				a.JSON[part[2]] = j
+			case "behavior":
+				if len(part) != 4 {
+					return nil, fmt.Errorf(
+						"-- behavior: <column> <field behavior>... takes exactly 2 arguments",
+					)
+				}
+				b, ok := annotations.FieldBehavior_value[strings.ToUpper(part[3])]
+				if !ok {
+					return nil, fmt.Errorf(
+						"-- behavior: %s is not a google.api.FieldBehavior",
+						part[3],
+					)
+				}
+				a.Behaviors[part[2]] = append(
+					a.Behaviors[part[2]],
+					annotations.FieldBehavior(b),
+				)
+			case "filename":
+				if len(part) != 3 {
+					return nil, fmt.Errorf(
+						"-- filename: <filename>... takes exactly 1 argument",
+					)
+				}
+				if filepath.Ext(part[2]) != ".proto" {
+					return nil, fmt.Errorf(
+						"-- filename: %s must end in .proto",
+						part[2],
+					)
+				}
+				a.FileName = part[2]
 			case "target":
 				if len(part) != 3 {
 					return nil, fmt.Errorf(
@@ -1051,6 +1990,13 @@ func parseAnnotations(comments []string) (*Annotations, error) {
 				}
 				skipField := part[2]
 				a.Skips = append(a.Skips, skipField)
+			case "nullable_elements":
+				if len(part) != 3 {
+					return nil, fmt.Errorf(
+						"-- nullable_elements: <column>... takes exactly 1 argument",
+					)
+				}
+				a.NullElements = append(a.NullElements, part[2])
 			case "request_response":
 				if len(part) < 3 {
 					return nil, fmt.Errorf(
@@ -1058,12 +2004,7 @@ func parseAnnotations(comments []string) (*Annotations, error) {
 					)
 				}
 				if a.ReqResp == nil {
-					es := []string{}
-					a.ReqResp = &ReqResp{
-						OneOf:     &es,
-						ReqFields: make(map[string]string),
-						RespEmpty: make(map[string]bool),
-					}
+					a.ReqResp = newReqResp()
 				}
 				switch part[2] {
 				case "oneof":
@@ -1091,6 +2032,20 @@ func parseAnnotations(comments []string) (*Annotations, error) {
 					}
 					a.ReqResp.RespEmpty = make(map[string]bool)
 					a.ReqResp.RespEmpty[part[3]] = true
+				case "update_mask":
+					if len(part) != 3 {
+						return nil, fmt.Errorf(
+							"-- request_response: update_mask takes no arguments.",
+						)
+					}
+					a.ReqResp.UpdateMask = true
+				case "parent":
+					if len(part) != 3 {
+						return nil, fmt.Errorf(
+							"-- request_response: parent takes no arguments.",
+						)
+					}
+					a.ReqResp.Parent = true
 				}
 			case "service":
 				if len(part) != 4 {
@@ -1108,6 +2063,33 @@ func parseAnnotations(comments []string) (*Annotations, error) {
 					Path: p,
 					Name: name,
 				}
+			case "resource":
+				if len(part) != 4 {
+					return nil, fmt.Errorf(
+						"-- resource: <type> <pattern> ... takes exactly 2 arguments",
+					)
+				}
+				r, err := newResource(part[2], part[3])
+				if err != nil {
+					return nil, err
+				}
+				a.Resource = r
+			case "rpc":
+				if len(part) != 3 && len(part) != 4 {
+					return nil, fmt.Errorf(
+						"-- rpc: <service> [path] ... takes 1 or 2 arguments",
+					)
+				}
+				a.RPC = &Service{
+					Name: part[2],
+				}
+				if len(part) == 4 {
+					p, err := url.Parse(part[3])
+					if err != nil {
+						return nil, err
+					}
+					a.RPC.Path = p
+				}
 			}
 		}
 	}
@@ -1159,91 +2141,457 @@ func setCommonProps(a *Annotations) error {
 		a.Package = DEFAULT_DEFAULT_PACKAGE
 	}
 
-	if err := validatePackageName(a.Package); err != nil {
-		return err
+	if err := validatePackageName(a.Package); err != nil {
+		return err
+	}
+
+	if a.OutDir == "" {
+		a.OutDir = DEFAULT_OUTDIR
+	}
+	// Example Package: foo.bar.baz.v1
+	// Example Filename: message.proto
+	// Path relative from root: foo/bar/baz/v1/message.proto
+	a.FullPath = fmt.Sprintf("%s/%s", pkgToPath(a.Package), a.FileName)
+
+	// Example Package: foo.bar.baz.v1
+	// Example Filename: message.proto
+	// Path relative from root: foo/bar/baz/v1/message.proto
+	// a.FullTypeName = fmt.Sprintf("%s.%s", a.Package, a.FileName)
+
+	// Full Filesystem path to be written to
+	a.OutputPath = fmt.Sprintf("%s/%s", a.OutDir, a.FullPath)
+
+	return nil
+}
+
+func setProps(input interface{}) error {
+	switch i := input.(type) {
+	case *query:
+		if !i.a.Generate {
+			return fmt.Errorf(DO_NOT_GENERATE)
+		}
+		if i.a.FileName == "" {
+			i.a.FileName = "message.proto"
+		}
+		if i.a.RPC != nil && i.a.ReqResp == nil {
+			i.a.ReqResp = newReqResp()
+		}
+		if err := setCommonProps(i.a); err != nil {
+			return err
+		}
+	case *enum:
+		if !i.a.Generate {
+			return fmt.Errorf(DO_NOT_GENERATE)
+		}
+		if i.a.FileName == "" {
+			i.a.FileName = "enum.proto"
+		}
+		if err := setCommonProps(i.a); err != nil {
+			return err
+		}
+	case *composite:
+		if !i.a.Generate {
+			return errors.New(DO_NOT_GENERATE)
+		}
+		if i.a.FileName == "" {
+			i.a.FileName = "message.proto"
+		}
+		if err := setCommonProps(i.a); err != nil {
+			return err
+		}
+	case *table:
+		if !i.a.Generate {
+			return fmt.Errorf(DO_NOT_GENERATE)
+		}
+		if i.a.FileName == "" {
+			i.a.FileName = "message.proto"
+		}
+		if err := setCommonProps(i.a); err != nil {
+			return err
+		}
+	case *ReqResp:
+		if !i.a.Generate {
+			return fmt.Errorf(DO_NOT_GENERATE)
+		}
+		if i.a.FileName == "" {
+			i.a.FileName = "request_response.proto"
+		}
+		if err := setCommonProps(i.a); err != nil {
+			return err
+		}
+	case *Service:
+		if !i.a.Generate {
+			return fmt.Errorf(DO_NOT_GENERATE)
+		}
+		if i.a.FileName == "" {
+			i.a.FileName = "service.proto"
+		}
+		if err := setCommonProps(i.a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type userDefinedString *string
+
+// columnTable is the table a query column or parameter comes from, if any.
+func columnTable(c *plugin.Column) string {
+	if c.Table == nil {
+		return ""
+	}
+	return c.Table.Name
+}
+
+// columnType applies -- replace: and then type_overrides before the
+// default conversion.  repeated is set for arrays, sqlc.slice,
+// multiranges and vectors.  Multi-dimensional arrays are wrapped in
+// messages, see arrayType.
+func (p *Protos) columnType(
+	c *plugin.Column,
+	a *Annotations,
+	table string,
+) (ft *protobuilder.FieldType, repeated bool, err error) {
+	ft, repeated, err = p.elementType(c, a, table)
+	if err != nil || !c.IsArray || c.ArrayDims < 2 {
+		return ft, repeated, err
+	}
+	ft, err = p.arrayType(ft, c.ArrayDims)
+	return ft, false, err
+}
+
+// elementType is columnType for a single dimension.
+func (p *Protos) elementType(
+	c *plugin.Column,
+	a *Annotations,
+	table string,
+) (ft *protobuilder.FieldType, repeated bool, err error) {
+	repeated = c.IsArray || c.IsSqlcSlice
+	if r, ok := a.Replace[c.Name]; ok {
+		ft, err := p.resolveProtoType(r, "")
+		if err != nil {
+			return nil, false, fmt.Errorf("-- replace: %w", err)
+		}
+		return ft, repeated, nil
+	}
+
+	if j, ok := a.JSON[c.Name]; ok {
+		jc := c
+		if dc := p.domainColumn(c); dc != nil {
+			jc = dc
+		}
+		switch dt := strings.TrimPrefix(strings.ToLower(sdk.DataType(jc.Type)), "pg_catalog."); dt {
+		case "json", "jsonb":
+		default:
+			return nil, false, fmt.Errorf("-- json: column is %s, not json or jsonb", dt)
+		}
+		ft, err := p.resolveProtoType(j.ProtoType, j.ProtoImport)
+		if err != nil {
+			return nil, false, fmt.Errorf("-- json: %w", err)
+		}
+		if ft.Kind() != protoreflect.MessageKind {
+			return nil, false, fmt.Errorf("-- json: %q is not a message", j.ProtoType)
+		}
+		return ft, repeated, nil
+	}
+
+	for i, o := range p.options.TypeOverrides {
+		if !o.matches(c, table) {
+			continue
+		}
+		ft, err := p.resolveProtoType(o.ProtoType, o.ProtoImport)
+		if err != nil {
+			return nil, false, fmt.Errorf("type_overrides[%d]: %w", i, err)
+		}
+		return ft, repeated, nil
+	}
+
+	if dc := p.domainColumn(c); dc != nil {
+		c = dc
+	}
+	if isRepeatedType(c) {
+		if repeated {
+			return nil, false, fmt.Errorf(
+				"%s[]: arrays of multiranges and vectors are not supported, use -- replace:",
+				sdk.DataType(c.Type),
+			)
+		}
+		repeated = true
+	}
+	// MySQL's inline ENUM(...) columns.
+	if e := p.catalogEnum(c); e != nil && p.options.engine == ENGINE_MYSQL {
+		ft, err = p.inlineEnum(e, a)
+		return ft, repeated, err
+	}
+	// A repeated scalar can not hold a null, the elements become wrappers
+	// whatever the nullable_style.
+	if c.IsArray && contains(a.NullElements, c.Name) {
+		dt := strings.ToLower(sdk.DataType(c.Type))
+		ft, err = p.convertType(userDefinedString(&dt))
+		if err == nil && ft.Kind() == protoreflect.EnumKind {
+			return nil, false, errors.New("-- nullable_elements: enums have no wrapper type, use -- replace:")
+		}
+		return ft, repeated, err
+	}
+	ft, err = p.convertType(c)
+	return ft, repeated, err
+}
+
+// arrayType wraps elem in one message per dimension, ex: int[][] is
+//
+//	message Int32Row { repeated int32 values = 1; }
+//	message Int32Matrix { repeated Int32Row rows = 1; }
+//
+// and every further dimension is an <Element>Array<N>D of the previous.
+// The messages live next to the element's message or enum, or in
+// common.proto for scalars and imported types.
+func (p *Protos) arrayType(elem *protobuilder.FieldType, dims int32) (*protobuilder.FieldType, error) {
+	eName := strcase.ToPascal(elem.Kind().String())
+	var fb *protobuilder.FileBuilder
+	if k := elem.Kind(); k == protoreflect.MessageKind || k == protoreflect.EnumKind {
+		eName = string(elem.TypeName().Name())
+		for _, f := range p.GetFiles() {
+			for _, child := range f.Children() {
+				if protobuilder.FullName(child) == elem.TypeName() {
+					fb = f
+				}
+			}
+		}
+	}
+	// common.proto is only created once something is generated into it.
+	var err error
+	if fb == nil {
+		if fb, err = p.commonFD(); err != nil {
+			return nil, err
+		}
+	}
+
+	ft := elem
+	for d := int32(1); d <= dims; d++ {
+		name, field := fmt.Sprintf("%sArray%dD", eName, d), "values"
+		switch d {
+		case 1:
+			name = eName + "Row"
+		case 2:
+			name, field = eName+"Matrix", "rows"
+		}
+		ft, err = p.fileMessage(
+			fb,
+			protoreflect.Name(name),
+			protobuilder.NewField(protoreflect.Name(field), ft).SetRepeated(),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ft, nil
+}
+
+// domainColumn returns c with its domain type replaced by the base type
+// from the domains option, or nil if c is not a domain.  Domains may be
+// configured with or without their schema.
+func (p *Protos) domainColumn(c *plugin.Column) *plugin.Column {
+	dt := strings.ToLower(sdk.DataType(c.Type))
+	base, ok := p.options.Domains[dt[strings.LastIndex(dt, ".")+1:]]
+	if !ok {
+		return nil
+	}
+
+	return &plugin.Column{
+		Name:        c.Name,
+		NotNull:     c.NotNull,
+		IsArray:     c.IsArray,
+		IsSqlcSlice: c.IsSqlcSlice,
+		Length:      c.Length,
+		Table:       c.Table,
+		Type:        &plugin.Identifier{Name: base},
+	}
+}
+
+// defaultColumn returns c, with its domain resolved, when neither
+// -- replace:, -- json: nor type_overrides apply to it, or nil.
+func (p *Protos) defaultColumn(c *plugin.Column, a *Annotations, table string) *plugin.Column {
+	if _, ok := a.Replace[c.Name]; ok {
+		return nil
+	}
+	if _, ok := a.JSON[c.Name]; ok {
+		return nil
+	}
+	for _, o := range p.options.TypeOverrides {
+		if o.matches(c, table) {
+			return nil
+		}
+	}
+	if dc := p.domainColumn(c); dc != nil {
+		return dc
+	}
+
+	return c
+}
+
+// isHstore reports if c is a single hstore, which becomes a map field.
+func isHstore(c *plugin.Column) bool {
+	if c == nil || c.IsArray || c.IsSqlcSlice {
+		return false
+	}
+	return strings.ToLower(sdk.DataType(c.Type)) == "hstore"
+}
+
+// columnField builds the field for a column or parameter.
+func (p *Protos) columnField(
+	name protoreflect.Name,
+	c *plugin.Column,
+	a *Annotations,
+	table string,
+) (*protobuilder.FieldBuilder, error) {
+	if isHstore(p.defaultColumn(c, a, table)) {
+		return protobuilder.NewMapField(
+			name,
+			protobuilder.FieldTypeString(),
+			protobuilder.FieldTypeString(),
+		), nil
+	}
+
+	ft, repeated, err := p.columnType(c, a, table)
+	if err != nil {
+		return nil, err
+	}
+	fieldb := protobuilder.NewField(name, ft)
+	if repeated {
+		fieldb.SetRepeated()
+	}
+	// Messages always have presence.
+	if p.options.NullableStyle == NULLABLE_STYLE_OPTIONAL &&
+		!c.NotNull && !repeated && ft.Kind() != protoreflect.MessageKind {
+		fieldb.SetProto3Optional(true)
+	}
+
+	// Keep what proto can not express for validators.
+	var comments []string
+	if dc := p.domainColumn(c); dc != nil {
+		comments = append(comments, fmt.Sprintf(" domain: %s", sdk.DataType(c.Type)))
+		c = dc
+	}
+	// Proto has no fixed length lists.
+	if isVector(c) && c.Length > 0 {
+		comments = append(comments, fmt.Sprintf(
+			" %s(%d): exactly %d elements.",
+			sdk.DataType(c.Type), c.Length, c.Length,
+		))
+	}
+	if len(comments) > 0 {
+		fieldb.SetComments(protobuilder.Comments{
+			LeadingComment: strings.Join(comments, "\n"),
+		})
+	}
+	return fieldb, nil
+}
+
+// importedType loads a proto_import from the user_defined_dir
+// and looks up name inside of it.
+func (p *Protos) importedType(importPath string, name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	fd, err := p.imports.FindFileByPath(importPath)
+	if err != nil {
+		ip := filepath.Join(p.options.UserDefinedDir, importPath)
+		f, err := os.Open(ip)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fdp, err := parseProto(importPath, f)
+		if err != nil {
+			return nil, err
+		}
+		fd, err = protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.imports.RegisterFile(fd); err != nil {
+			return nil, err
+		}
+	}
+
+	if d := fd.Messages().ByName(name.Name()); d != nil && d.FullName() == name {
+		return d, nil
 	}
-
-	if a.OutDir == "" {
-		a.OutDir = DEFAULT_OUTDIR
+	if d := fd.Enums().ByName(name.Name()); d != nil && d.FullName() == name {
+		return d, nil
 	}
-	// Example Package: foo.bar.baz.v1
-	// Example Filename: message.proto
-	// Path relative from root: foo/bar/baz/v1/message.proto
-	a.FullPath = fmt.Sprintf("%s/%s", pkgToPath(a.Package), a.FileName)
-
-	// Example Package: foo.bar.baz.v1
-	// Example Filename: message.proto
-	// Path relative from root: foo/bar/baz/v1/message.proto
-	// a.FullTypeName = fmt.Sprintf("%s.%s", a.Package, a.FileName)
-
-	// Full Filesystem path to be written to
-	a.OutputPath = fmt.Sprintf("%s/%s", a.OutDir, a.FullPath)
+	return nil, fmt.Errorf("%q: not found in %q", name, importPath)
+}
 
-	return nil
+var protoScalars = map[string]protoreflect.Kind{
+	protoDouble:   protoreflect.DoubleKind,
+	protoFloat:    protoreflect.FloatKind,
+	protoInt32:    protoreflect.Int32Kind,
+	protoInt64:    protoreflect.Int64Kind,
+	protoUint32:   protoreflect.Uint32Kind,
+	protoUint64:   protoreflect.Uint64Kind,
+	protoSint32:   protoreflect.Sint32Kind,
+	protoSint64:   protoreflect.Sint64Kind,
+	protoFixed32:  protoreflect.Fixed32Kind,
+	protoFixed64:  protoreflect.Fixed64Kind,
+	protoSFixed32: protoreflect.Sfixed32Kind,
+	protoSFixed64: protoreflect.Sfixed64Kind,
+	protoBool:     protoreflect.BoolKind,
+	protoString:   protoreflect.StringKind,
+	protoBytes:    protoreflect.BytesKind,
 }
 
-func setProps(input interface{}) error {
-	switch i := input.(type) {
-	case *query:
-		if !i.a.Generate {
-			return fmt.Errorf(DO_NOT_GENERATE)
-		}
-		if i.a.Target == "" {
-			return fmt.Errorf(
-				"To append columns from Queries to protobufs  you must declare a target: <message-to-append-to>",
-			)
+// resolveProtoType resolves a proto type name to a scalar, a well known
+// or googleapis type, a type from importPath, or a generated message or
+// enum.  Imports are added by the builder when the file is built.
+func (p *Protos) resolveProtoType(name string, importPath string) (*protobuilder.FieldType, error) {
+	if kind, ok := protoScalars[name]; ok {
+		return protobuilder.FieldTypeScalar(kind), nil
+	}
 
+	fullName := protoreflect.FullName(strings.TrimPrefix(name, "."))
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(fullName)
+	if err != nil && importPath != "" {
+		d, err = p.importedType(importPath, fullName)
+		if err != nil {
+			return nil, err
 		}
-		if err := setCommonProps(i.a); err != nil {
-			return err
-		}
-	case *enum:
-		if !i.a.Generate {
-			return fmt.Errorf(DO_NOT_GENERATE)
-		}
-		if i.a.FileName == "" {
-			i.a.FileName = "enum.proto"
-		}
-		if err := setCommonProps(i.a); err != nil {
-			return err
-		}
-	case *table:
-		if !i.a.Generate {
-			return fmt.Errorf(DO_NOT_GENERATE)
-		}
-		if i.a.FileName == "" {
-			i.a.FileName = "message.proto"
-		}
-		if err := setCommonProps(i.a); err != nil {
-			return err
-		}
-	case *ReqResp:
-		if !i.a.Generate {
-			return fmt.Errorf(DO_NOT_GENERATE)
-		}
-		if i.a.FileName == "" {
-			i.a.FileName = "request_response.proto"
-		}
-		if err := setCommonProps(i.a); err != nil {
-			return err
+	}
+	if err == nil {
+		switch d := d.(type) {
+		case protoreflect.MessageDescriptor:
+			return protobuilder.FieldTypeImportedMessage(d), nil
+		case protoreflect.EnumDescriptor:
+			return protobuilder.FieldTypeImportedEnum(d), nil
 		}
-	case *Service:
-		if !i.a.Generate {
-			return fmt.Errorf(DO_NOT_GENERATE)
+	}
+
+	// Generated messages and enums, by full name first then by name.
+	for _, f := range p.GetFiles() {
+		for _, child := range f.Children() {
+			if protobuilder.FullName(child) != fullName {
+				continue
+			}
+			switch b := child.(type) {
+			case *protobuilder.MessageBuilder:
+				return protobuilder.FieldTypeMessage(b), nil
+			case *protobuilder.EnumBuilder:
+				return protobuilder.FieldTypeEnum(b), nil
+			}
 		}
-		if i.a.FileName == "" {
-			i.a.FileName = "service.proto"
+	}
+	tName := protoreflect.Name(fullName.Name())
+	for _, f := range p.GetFiles() {
+		if mb := f.GetMessage(tName); mb != nil {
+			return protobuilder.FieldTypeMessage(mb), nil
 		}
-		if err := setCommonProps(i.a); err != nil {
-			return err
+		if eb := f.GetEnum(tName); eb != nil {
+			return protobuilder.FieldTypeEnum(eb), nil
 		}
 	}
 
-	return nil
+	return nil, fmt.Errorf("%q: unknown proto type", name)
 }
 
-type userDefinedString *string
-
 func (p *Protos) convertType(input interface{}) (*protobuilder.FieldType, error) {
 	var ct string
 	var notNull bool
@@ -1256,10 +2604,39 @@ func (p *Protos) convertType(input interface{}) (*protobuilder.FieldType, error)
 		ct = *i
 	case *plugin.Column:
 		s := sdk.DataType(i.Type)
-		notNull = i.NotNull || i.IsArray
+		notNull = i.NotNull || i.IsArray || i.IsSqlcSlice
+		// Wrappers are only used for nullable_style: wrappers, see columnField.
+		notNull = notNull || p.options.NullableStyle != NULLABLE_STYLE_WRAPPERS
 		ct = strings.ToLower(s)
 	}
 
+	switch ct {
+	case "json", "jsonb", "pg_catalog.json", "pg_catalog.jsonb":
+		if p.options.JSONType != "" {
+			return p.jsonType(notNull)
+		}
+	}
+
+	// Engine specific names only apply to columns, not proto type names.
+	if c, ok := input.(*plugin.Column); ok {
+		switch p.options.engine {
+		case ENGINE_MYSQL:
+			if ft := p.mysqlType(ct, notNull, c); ft != nil {
+				return ft, nil
+			}
+		case ENGINE_SQLITE:
+			if ft := p.sqliteType(ct, notNull); ft != nil {
+				return ft, nil
+			}
+		}
+	}
+
+	if p.options.TypeMapping == TYPE_MAPPING_FAITHFUL {
+		if ft := faithfulType(ct, notNull); ft != nil {
+			return ft, nil
+		}
+	}
+
 	tAny := (*anypb.Any)(nil).ProtoReflect().Descriptor()
 	tI32 := (*wrapperspb.Int32Value)(nil).ProtoReflect().Descriptor()
 	tI64 := (*wrapperspb.Int64Value)(nil).ProtoReflect().Descriptor()
@@ -1391,204 +2768,66 @@ func (p *Protos) convertType(input interface{}) (*protobuilder.FieldType, error)
 			tString,
 		), nil
 
-	// All these PG Range Types Required FieldOptions
-	// Handle this Later
-	case "daterange":
-		// switch driver {
-		// case opts.SQLDriverPGXV4:
-		// 	return "pgtype.Daterange"
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Range[pgtype.Date]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "datemultirange":
-		// switch driver {
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Multirange[pgtype.Range[pgtype.Date]]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "tsrange":
-		// switch driver {
-		// case opts.SQLDriverPGXV4:
-		// 	return "pgtype.Tsrange"
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Range[pgtype.Timestamp]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "tsmultirange":
-		// switch driver {
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Multirange[pgtype.Range[pgtype.Timestamp]]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "tstzrange":
-		// switch driver {
-		// case opts.SQLDriverPGXV4:
-		// 	return "pgtype.Tstzrange"
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Range[pgtype.Timestamptz]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "tstzmultirange":
-		// switch driver {
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Multirange[pgtype.Range[pgtype.Timestamptz]]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "numrange":
-		// switch driver {
-		// case opts.SQLDriverPGXV4:
-		// 	return "pgtype.Numrange"
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Range[pgtype.Numeric]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "nummultirange":
-		// switch driver {
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Multirange[pgtype.Range[pgtype.Numeric]]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "int4range":
-		// switch driver {
-		// case opts.SQLDriverPGXV4:
-		// 	return "pgtype.Int4range"
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Range[pgtype.Int4]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "int4multirange":
-		// switch driver {
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Multirange[pgtype.Range[pgtype.Int4]]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "int8range":
-		// switch driver {
-		// case opts.SQLDriverPGXV4:
-		// 	return "pgtype.Int8range"
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Range[pgtype.Int8]"
-		// default:
-		// 	return "interface{}"
-		// }
-
-	case "int8multirange":
-		// switch driver {
-		// case opts.SQLDriverPGXV5:
-		// 	return "pgtype.Multirange[pgtype.Range[pgtype.Int8]]"
-		// default:
-		// 	return "interface{}"
-		// }
-
+	// Ranges become shared <Element>Range messages, multiranges
+	// a repeated field of them.  See rangeType and columnType.
+	case "daterange",
+		"datemultirange",
+		"tsrange",
+		"tsmultirange",
+		"tstzrange",
+		"tstzmultirange",
+		"numrange",
+		"nummultirange",
+		"int4range",
+		"int4multirange",
+		"int8range",
+		"int8multirange":
+		return p.rangeType(ct)
+
+	// hstore is a map<string, string>, see columnField.  Maps can not be
+	// repeated so only arrays of hstore get here.
 	case "hstore":
-		// if driver.IsPGX() {
-		// 	return "pgtype.Hstore"
-		// }
-		return protobuilder.FieldTypeImportedMessage(
-			tAny,
-		), nil
+		return nil, fmt.Errorf("%s: arrays of hstore are not supported, use -- replace:", ct)
 
+	// bit strings keep their length, the last byte may be partial.
 	case "bit", "varbit", "pg_catalog.bit", "pg_catalog.varbit":
-		// if driver == opts.SQLDriverPGXV5 {
-		// 	return "pgtype.Bits"
-		// }
-		// if driver == opts.SQLDriverPGXV4 {
-		// 	return "pgtype.Varbit"
-		// }
-
-	case "cid":
-		// if driver == opts.SQLDriverPGXV5 {
-		// 	return "pgtype.Uint32"
-		// }
-		// if driver == opts.SQLDriverPGXV4 {
-		// 	return "pgtype.CID"
-		// }
-
-	case "oid":
-		// if driver == opts.SQLDriverPGXV5 {
-		// 	return "pgtype.Uint32"
-		// }
-		// if driver == opts.SQLDriverPGXV4 {
-		// 	return "pgtype.OID"
-		// }
-
-	case "tid":
-		// if driver.IsPGX() {
-		// 	return "pgtype.TID"
-		// }
-
-	case "xid":
-		// if driver == opts.SQLDriverPGXV5 {
-		// 	return "pgtype.Uint32"
-		// }
-		// if driver == opts.SQLDriverPGXV4 {
-		// 	return "pgtype.XID"
-		// }
-
-	case "box":
-		// if driver.IsPGX() {
-		// 	return "pgtype.Box"
-		// }
-
-	case "circle":
-		// if driver.IsPGX() {
-		// 	return "pgtype.Circle"
-		// }
-
-	case "line":
-		// if driver.IsPGX() {
-		// 	return "pgtype.Line"
-		// }
-
-	case "lseg":
-		// if driver.IsPGX() {
-		// 	return "pgtype.Lseg"
-		// }
+		return p.commonMessage(
+			"BitString",
+			protobuilder.NewField("bits", protobuilder.FieldTypeBytes()),
+			protobuilder.NewField("length", protobuilder.FieldTypeInt32()),
+		)
 
-	case "path":
-		// if driver.IsPGX() {
-		// 	return "pgtype.Path"
-		// }
+	case "oid", "xid", "cid", "pg_catalog.oid", "pg_catalog.xid", "pg_catalog.cid":
+		if notNull {
+			return protobuilder.FieldTypeUint32(), nil
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*wrapperspb.UInt32Value)(nil).ProtoReflect().Descriptor(),
+		), nil
 
-	case "point":
-		// if driver.IsPGX() {
-		// 	return "pgtype.Point"
-		// }
+	case "tid", "pg_catalog.tid":
+		return p.commonMessage(
+			"Tid",
+			protobuilder.NewField("block", protobuilder.FieldTypeUint32()),
+			protobuilder.NewField("offset", protobuilder.FieldTypeUint32()),
+		)
 
-	case "polygon":
-		// if driver.IsPGX() {
-		// 	return "pgtype.Polygon"
-		// }
-
-	case "vector":
-		// if driver == opts.SQLDriverPGXV5 {
-		// 	if emitPointersForNull {
-		// 		return "*pgvector.Vector"
-		// 	} else {
-		// 		return "pgvector.Vector"
-		// 	}
-		// }
+	case "point", "line", "lseg", "box", "path", "polygon", "circle",
+		"pg_catalog.point", "pg_catalog.line", "pg_catalog.lseg", "pg_catalog.box",
+		"pg_catalog.path", "pg_catalog.polygon", "pg_catalog.circle":
+		return p.geometricType(strings.TrimPrefix(ct, "pg_catalog."))
+
+	// pgvector, repeated and dimensions are handled by columnField.
+	case "vector", "halfvec":
+		return protobuilder.FieldTypeFloat(), nil
+
+	case "sparsevec":
+		return p.commonMessage(
+			"SparseVector",
+			protobuilder.NewField("dimensions", protobuilder.FieldTypeInt32()),
+			protobuilder.NewField("indices", protobuilder.FieldTypeInt32()).SetRepeated(),
+			protobuilder.NewField("values", protobuilder.FieldTypeFloat()).SetRepeated(),
+		)
 
 	case "void":
 		// A void value can only be scanned into an empty interface.
@@ -1629,6 +2868,279 @@ func (p *Protos) convertType(input interface{}) (*protobuilder.FieldType, error)
 	return nil, fmt.Errorf("%s: Type Conversion Not Implemented.  Use --replace: or --skip:", ct)
 }
 
+// scalarType returns kind, or its google.protobuf wrapper when nullable.
+func scalarType(kind protoreflect.Kind, notNull bool) *protobuilder.FieldType {
+	if notNull {
+		return protobuilder.FieldTypeScalar(kind)
+	}
+
+	var wrapper proto.Message
+	switch kind {
+	case protoreflect.BoolKind:
+		wrapper = (*wrapperspb.BoolValue)(nil)
+	case protoreflect.Int32Kind:
+		wrapper = (*wrapperspb.Int32Value)(nil)
+	case protoreflect.Uint32Kind:
+		wrapper = (*wrapperspb.UInt32Value)(nil)
+	case protoreflect.Int64Kind:
+		wrapper = (*wrapperspb.Int64Value)(nil)
+	case protoreflect.Uint64Kind:
+		wrapper = (*wrapperspb.UInt64Value)(nil)
+	case protoreflect.FloatKind:
+		wrapper = (*wrapperspb.FloatValue)(nil)
+	case protoreflect.DoubleKind:
+		wrapper = (*wrapperspb.DoubleValue)(nil)
+	case protoreflect.StringKind:
+		wrapper = (*wrapperspb.StringValue)(nil)
+	case protoreflect.BytesKind:
+		wrapper = (*wrapperspb.BytesValue)(nil)
+	default:
+		return protobuilder.FieldTypeScalar(kind)
+	}
+
+	return protobuilder.FieldTypeImportedMessage(wrapper.ProtoReflect().Descriptor())
+}
+
+// jsonType is the json_type conversion for json and jsonb.
+func (p *Protos) jsonType(notNull bool) (*protobuilder.FieldType, error) {
+	switch p.options.JSONType {
+	case JSON_TYPE_STRING:
+		if notNull {
+			return protobuilder.FieldTypeString(), nil
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*wrapperspb.StringValue)(nil).ProtoReflect().Descriptor(),
+		), nil
+	case JSON_TYPE_BYTES:
+		if notNull {
+			return protobuilder.FieldTypeBytes(), nil
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*wrapperspb.BytesValue)(nil).ProtoReflect().Descriptor(),
+		), nil
+	}
+
+	// google.protobuf.Value or google.protobuf.Struct
+	return p.resolveProtoType(p.options.JSONType, "")
+}
+
+// faithfulType is the type_mapping: faithful conversion for the types the
+// legacy mapping loses precision on.  Returns nil for everything else.
+func faithfulType(ct string, notNull bool) *protobuilder.FieldType {
+	switch strings.TrimPrefix(ct, "pg_catalog.") {
+	case "double precision", "float", "float8":
+		if notNull {
+			return protobuilder.FieldTypeDouble()
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*wrapperspb.DoubleValue)(nil).ProtoReflect().Descriptor(),
+		)
+	case "interval":
+		return protobuilder.FieldTypeImportedMessage(
+			(*durationpb.Duration)(nil).ProtoReflect().Descriptor(),
+		)
+	case "date":
+		return protobuilder.FieldTypeImportedMessage(
+			(*date.Date)(nil).ProtoReflect().Descriptor(),
+		)
+	case "time":
+		return protobuilder.FieldTypeImportedMessage(
+			(*timeofday.TimeOfDay)(nil).ProtoReflect().Descriptor(),
+		)
+	// TimeOfDay has no offset, the ISO 8601 string keeps it, ex: 15:04:05-07:00.
+	case "timetz":
+		if notNull {
+			return protobuilder.FieldTypeString()
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*wrapperspb.StringValue)(nil).ProtoReflect().Descriptor(),
+		)
+	case "timestamp":
+		return protobuilder.FieldTypeImportedMessage(
+			(*datetime.DateTime)(nil).ProtoReflect().Descriptor(),
+		)
+	}
+
+	return nil
+}
+
+// rangeElements is the element type of every range type, multiranges
+// map to the range they are made of.
+var (
+	rangeElements = map[string]string{
+		"daterange": "date",
+		"tsrange":   "pg_catalog.timestamp",
+		"tstzrange": "pg_catalog.timestamptz",
+		"numrange":  "pg_catalog.numeric",
+		"int4range": "pg_catalog.int4",
+		"int8range": "pg_catalog.int8",
+	}
+	multiranges = map[string]string{
+		"datemultirange": "daterange",
+		"tsmultirange":   "tsrange",
+		"tstzmultirange": "tstzrange",
+		"nummultirange":  "numrange",
+		"int4multirange": "int4range",
+		"int8multirange": "int8range",
+	}
+)
+
+// isRepeatedType reports if a column's type is itself a list, multiranges
+// and vectors, and has to be repeated.
+func isRepeatedType(c *plugin.Column) bool {
+	_, ok := multiranges[strings.TrimPrefix(strings.ToLower(sdk.DataType(c.Type)), "pg_catalog.")]
+	return ok || isVector(c)
+}
+
+// isVector reports if a column is a pgvector vector or halfvec.
+func isVector(c *plugin.Column) bool {
+	switch strings.ToLower(sdk.DataType(c.Type)) {
+	case "vector", "halfvec":
+		return true
+	}
+	return false
+}
+
+// commonFD is common.proto in the default_package, home of the messages
+// shared by every table such as the Range types.
+func (p *Protos) commonFD() (*protobuilder.FileBuilder, error) {
+	a := &Annotations{Generate: true, FileName: "common.proto"}
+	if err := setCommonProps(a); err != nil {
+		return nil, err
+	}
+	return p.getFD(a), nil
+}
+
+// rangeType returns the <Element>Range message for a range or multirange,
+// creating it in common.proto on first use.  Ranges sharing an element
+// type, ex: tsrange and tstzrange, share a message.  Bounds are always
+// nullable since a range can be unbounded on either side.
+func (p *Protos) rangeType(ct string) (*protobuilder.FieldType, error) {
+	rt := strings.TrimPrefix(ct, "pg_catalog.")
+	if r, ok := multiranges[rt]; ok {
+		rt = r
+	}
+	elem, ok := rangeElements[rt]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown range type", ct)
+	}
+	bound, err := p.convertType(userDefinedString(&elem))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ct, err)
+	}
+
+	rName := protoreflect.Name(
+		strings.TrimSuffix(string(bound.TypeName().Name()), "Value") + "Range",
+	)
+
+	return p.commonMessage(
+		rName,
+		protobuilder.NewField("lower", bound),
+		protobuilder.NewField("upper", bound),
+		protobuilder.NewField("lower_inclusive", protobuilder.FieldTypeBool()),
+		protobuilder.NewField("upper_inclusive", protobuilder.FieldTypeBool()),
+		protobuilder.NewField("empty", protobuilder.FieldTypeBool()),
+	)
+}
+
+// commonMessage returns the message name in common.proto, adding it with
+// fields on first use.
+func (p *Protos) commonMessage(
+	name protoreflect.Name,
+	fields ...*protobuilder.FieldBuilder,
+) (*protobuilder.FieldType, error) {
+	fb, err := p.commonFD()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.fileMessage(fb, name, fields...)
+}
+
+// fileMessage returns the message name in fb, adding it with fields on
+// first use.
+func (p *Protos) fileMessage(
+	fb *protobuilder.FileBuilder,
+	name protoreflect.Name,
+	fields ...*protobuilder.FieldBuilder,
+) (*protobuilder.FieldType, error) {
+	if mb := fb.GetMessage(name); mb != nil {
+		return protobuilder.FieldTypeMessage(mb), nil
+	}
+
+	mb := protobuilder.NewMessage(name)
+	for _, f := range fields {
+		if err := mb.TryAddField(f); err != nil {
+			return nil, err
+		}
+	}
+	if err := fb.TryAddMessage(mb); err != nil {
+		return nil, err
+	}
+
+	return protobuilder.FieldTypeMessage(mb), nil
+}
+
+// geometricType returns the common.proto message for a geometric type,
+// every one of them but line is made of Points.
+func (p *Protos) geometricType(ct string) (*protobuilder.FieldType, error) {
+	double := protobuilder.FieldTypeDouble
+	if ct == "line" {
+		// {A,B,C} of Ax + By + C = 0
+		return p.commonMessage(
+			"Line",
+			protobuilder.NewField("a", double()),
+			protobuilder.NewField("b", double()),
+			protobuilder.NewField("c", double()),
+		)
+	}
+
+	point, err := p.commonMessage(
+		"Point",
+		protobuilder.NewField("x", double()),
+		protobuilder.NewField("y", double()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ct {
+	case "point":
+		return point, nil
+	case "lseg":
+		return p.commonMessage(
+			"LineSegment",
+			protobuilder.NewField("start", point),
+			protobuilder.NewField("end", point),
+		)
+	case "box":
+		return p.commonMessage(
+			"Box",
+			protobuilder.NewField("upper_right", point),
+			protobuilder.NewField("lower_left", point),
+		)
+	case "path":
+		return p.commonMessage(
+			"Path",
+			protobuilder.NewField("points", point).SetRepeated(),
+			protobuilder.NewField("closed", protobuilder.FieldTypeBool()),
+		)
+	case "polygon":
+		return p.commonMessage(
+			"Polygon",
+			protobuilder.NewField("points", point).SetRepeated(),
+		)
+	case "circle":
+		return p.commonMessage(
+			"Circle",
+			protobuilder.NewField("center", point),
+			protobuilder.NewField("radius", double()),
+		)
+	}
+
+	return nil, fmt.Errorf("%s: unknown geometric type", ct)
+}
+
 func stringToKind(typeString string) (protoreflect.Kind, error) {
 	switch strings.ToUpper(typeString) {
 	case "TYPE_DOUBLE":
@@ -1686,10 +3198,94 @@ func convertEnumValues(n string, s []string) []string {
 }
 
 type ReqResp struct {
-	OneOf     *[]string
-	ReqFields map[string]string
-	RespEmpty map[string]bool
-	a         *Annotations
+	OneOf      *[]string
+	ReqFields  map[string]string
+	RespEmpty  map[string]bool
+	UpdateMask bool
+	Parent     bool
+	a          *Annotations
+}
+
+func newReqResp() *ReqResp {
+	es := []string{}
+	return &ReqResp{
+		OneOf:     &es,
+		ReqFields: make(map[string]string),
+		RespEmpty: make(map[string]bool),
+	}
+}
+
+// Resource is an AIP-123 resource, ex: iam.example.com/User users/{user}
+type Resource struct {
+	Type    string
+	Pattern string
+}
+
+func newResource(typ, pattern string) (*Resource, error) {
+	t := strings.Split(typ, "/")
+	if len(t) != 2 || t[0] == "" || t[1] == "" {
+		return nil, fmt.Errorf(
+			"-- resource: %s type must be <service name>/<Kind>", typ,
+		)
+	}
+	// collection/{variable}/collection/{variable}
+	segs := strings.Split(pattern, "/")
+	if len(segs)%2 != 0 {
+		return nil, fmt.Errorf(
+			"-- resource: %s pattern must alternate collections and {variables}", pattern,
+		)
+	}
+	for i, seg := range segs {
+		isVar := strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && len(seg) > 2
+		if seg == "" || isVar != (i%2 == 1) {
+			return nil, fmt.Errorf(
+				"-- resource: %s pattern must alternate collections and {variables}", pattern,
+			)
+		}
+	}
+	return &Resource{Type: typ, Pattern: pattern}, nil
+}
+
+// singular is the lowerCamel Kind, ex: user
+func (r *Resource) singular() string {
+	return strcase.ToCamel(r.Type[strings.Index(r.Type, "/")+1:])
+}
+
+// plural is the resource's own collection, ex: users
+func (r *Resource) plural() string {
+	segs := strings.Split(r.Pattern, "/")
+	return segs[len(segs)-2]
+}
+
+// wildcard replaces the pattern variables for an http rule, ex: users/*
+func (r *Resource) wildcard() string {
+	segs := strings.Split(r.Pattern, "/")
+	for i := 1; i < len(segs); i += 2 {
+		segs[i] = "*"
+	}
+	return strings.Join(segs, "/")
+}
+
+// prefix is the service path before the pattern, ex: /v1 for /v1/users
+func (r *Resource) prefix(path string) string {
+	first := r.Pattern[:strings.Index(r.Pattern, "/")]
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if seg == first {
+			return strings.Join(segs[:i], "/")
+		}
+	}
+	return strings.TrimSuffix(path, "/")
+}
+
+// descriptor is the google.api.resource message option.
+func (r *Resource) descriptor() *annotations.ResourceDescriptor {
+	return &annotations.ResourceDescriptor{
+		Type:     r.Type,
+		Pattern:  []string{r.Pattern},
+		Singular: r.singular(),
+		Plural:   r.plural(),
+	}
 }
 
 type Service struct {
@@ -1698,6 +3294,41 @@ type Service struct {
 	a    *Annotations
 }
 
+// params are the path parameters, ex: [org project]
+func (s *Service) params() []string {
+	return parseDynamicPath(s.Path.Path)
+}
+
+// parentPath binds the path parameters to a single parent field,
+// ex: /v1/orgs/{org}/projects/{project}/users is /v1/{parent=orgs/*/projects/*}/users
+func (s *Service) parentPath() string {
+	segs := strings.Split(s.Path.Path, "/")
+	first, last := -1, -1
+	for i, seg := range segs {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 1 {
+		return s.Path.Path
+	}
+
+	parent := make([]string, 0, last-first+2)
+	for _, seg := range segs[first-1 : last+1] {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			seg = "*"
+		}
+		parent = append(parent, seg)
+	}
+	out := append([]string{}, segs[:first-1]...)
+	out = append(out, fmt.Sprintf("{parent=%s}", strings.Join(parent, "/")))
+	out = append(out, segs[last+1:]...)
+	return strings.Join(out, "/")
+}
+
 type httpOptions struct {
 	Method string
 	Body   string
@@ -1776,4 +3407,5 @@ const (
 	WellKnownType             = wkprefix + "Type"
 	WellKnownUInt32Value      = wkprefix + "UInt32Value"
 	WellKnownUInt64Value      = wkprefix + "UInt64Value"
+	WellKnownValue            = wkprefix + "Value"
 )
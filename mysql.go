@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/jhump/protoreflect/v2/protobuilder"
+	"github.com/sqlc-dev/sqlc/internal/codegen/sdk"
+	"github.com/sqlc-dev/sqlc/internal/plugin"
+	"google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/genproto/googleapis/type/datetime"
+	"google.golang.org/genproto/googleapis/type/decimal"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// mysqlType is the engine: mysql conversion, it runs before the shared
+// conversion and returns nil for types that are the same as Postgres,
+// ex: json or an enum already generated.
+func (p *Protos) mysqlType(ct string, notNull bool, c *plugin.Column) *protobuilder.FieldType {
+	switch ct {
+	// tinyint(1) and bit(1) are MySQL's booleans.
+	case "bool", "boolean":
+		return scalarType(protoreflect.BoolKind, notNull)
+	case "tinyint", "bit":
+		if c.Length == 1 {
+			return scalarType(protoreflect.BoolKind, notNull)
+		}
+		if ct == "bit" {
+			return scalarType(protoreflect.Uint64Kind, notNull)
+		}
+		fallthrough
+	case "smallint", "mediumint", "int", "integer", "year":
+		if c.Unsigned {
+			return scalarType(protoreflect.Uint32Kind, notNull)
+		}
+		return scalarType(protoreflect.Int32Kind, notNull)
+	case "bigint":
+		if c.Unsigned {
+			return scalarType(protoreflect.Uint64Kind, notNull)
+		}
+		return scalarType(protoreflect.Int64Kind, notNull)
+
+	case "float":
+		return scalarType(protoreflect.FloatKind, notNull)
+	case "double", "double precision", "real":
+		return scalarType(protoreflect.DoubleKind, notNull)
+	case "decimal", "dec", "numeric", "fixed":
+		return protobuilder.FieldTypeImportedMessage(
+			(*decimal.Decimal)(nil).ProtoReflect().Descriptor(),
+		)
+
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "set", "enum":
+		return scalarType(protoreflect.StringKind, notNull)
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return scalarType(protoreflect.BytesKind, notNull)
+
+	// timestamp is an instant, datetime and date are civil times and
+	// time is an elapsed time that may exceed 24 hours.
+	case "timestamp":
+		return protobuilder.FieldTypeImportedMessage(
+			(*timestamppb.Timestamp)(nil).ProtoReflect().Descriptor(),
+		)
+	case "datetime":
+		if p.options.TypeMapping == TYPE_MAPPING_FAITHFUL {
+			return protobuilder.FieldTypeImportedMessage(
+				(*datetime.DateTime)(nil).ProtoReflect().Descriptor(),
+			)
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*timestamppb.Timestamp)(nil).ProtoReflect().Descriptor(),
+		)
+	case "date":
+		if p.options.TypeMapping == TYPE_MAPPING_FAITHFUL {
+			return protobuilder.FieldTypeImportedMessage(
+				(*date.Date)(nil).ProtoReflect().Descriptor(),
+			)
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*timestamppb.Timestamp)(nil).ProtoReflect().Descriptor(),
+		)
+	case "time":
+		return protobuilder.FieldTypeImportedMessage(
+			(*durationpb.Duration)(nil).ProtoReflect().Descriptor(),
+		)
+	}
+
+	return nil
+}
+
+// catalogEnum returns the catalog enum a column is typed as, generated or
+// not.  MySQL creates one per inline ENUM(...) column, named
+// <table>_<column>.
+func (p *Protos) catalogEnum(c *plugin.Column) *plugin.Enum {
+	dt := strings.ToLower(sdk.DataType(c.Type))
+	return p.catalogEnums[dt[strings.LastIndex(dt, ".")+1:]]
+}
+
+// inlineEnum returns the proto enum for a MySQL ENUM(...) column.  Inline
+// enums carry no annotations, so unless the enum is already generated it
+// is added to the file of the table or query using it.
+func (p *Protos) inlineEnum(e *plugin.Enum, a *Annotations) (*protobuilder.FieldType, error) {
+	pn := *toPascal(e.Name)
+	eName := protoreflect.Name(pn)
+	for _, f := range p.GetFiles() {
+		if eb := f.GetEnum(eName); eb != nil {
+			return protobuilder.FieldTypeEnum(eb), nil
+		}
+	}
+
+	eb := protobuilder.NewEnum(eName)
+	for _, val := range convertEnumValues(pn, e.Vals) {
+		if err := eb.TryAddValue(protobuilder.NewEnumValue(protoreflect.Name(val))); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.getFD(a).TryAddEnum(eb); err != nil {
+		return nil, err
+	}
+
+	return protobuilder.FieldTypeEnum(eb), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/jhump/protoreflect/v2/protobuilder"
+	"google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/genproto/googleapis/type/datetime"
+	"google.golang.org/genproto/googleapis/type/decimal"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// sqliteType is the engine: sqlite conversion.  A few declared types sqlc
+// gives a meaning to are mapped first, everything else follows SQLite's
+// column affinity rules, https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+// Returns nil for json which is the same as Postgres.
+func (p *Protos) sqliteType(ct string, notNull bool) *protobuilder.FieldType {
+	switch ct {
+	case "bool", "boolean":
+		return scalarType(protoreflect.BoolKind, notNull)
+	case "date":
+		if p.options.TypeMapping == TYPE_MAPPING_FAITHFUL {
+			return protobuilder.FieldTypeImportedMessage(
+				(*date.Date)(nil).ProtoReflect().Descriptor(),
+			)
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*timestamppb.Timestamp)(nil).ProtoReflect().Descriptor(),
+		)
+	case "datetime":
+		if p.options.TypeMapping == TYPE_MAPPING_FAITHFUL {
+			return protobuilder.FieldTypeImportedMessage(
+				(*datetime.DateTime)(nil).ProtoReflect().Descriptor(),
+			)
+		}
+		return protobuilder.FieldTypeImportedMessage(
+			(*timestamppb.Timestamp)(nil).ProtoReflect().Descriptor(),
+		)
+	case "timestamp":
+		return protobuilder.FieldTypeImportedMessage(
+			(*timestamppb.Timestamp)(nil).ProtoReflect().Descriptor(),
+		)
+	case "json", "jsonb":
+		return nil
+	}
+
+	// The rules are applied in order, the first match wins.
+	switch {
+	case strings.Contains(ct, "int"):
+		return scalarType(protoreflect.Int64Kind, notNull)
+	case strings.Contains(ct, "char"),
+		strings.Contains(ct, "clob"),
+		strings.Contains(ct, "text"):
+		return scalarType(protoreflect.StringKind, notNull)
+	case strings.Contains(ct, "blob"), ct == "", ct == "any":
+		return scalarType(protoreflect.BytesKind, notNull)
+	case strings.Contains(ct, "real"),
+		strings.Contains(ct, "floa"),
+		strings.Contains(ct, "doub"):
+		return scalarType(protoreflect.DoubleKind, notNull)
+	}
+
+	// NUMERIC affinity, ex: numeric and decimal.
+	return protobuilder.FieldTypeImportedMessage(
+		(*decimal.Decimal)(nil).ProtoReflect().Descriptor(),
+	)
+}